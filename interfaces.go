@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linode/rad-unnumbered/radunnumbered"
+	ll "github.com/sirupsen/logrus"
+)
+
+// splitInterfaces splits a comma and/or whitespace separated list of interface names or indices
+func splitInterfaces(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// readInterfacesFile reads interface names or indices from path, one or more per line. # starts a comment
+func readInterfacesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read interfaces file: %w", err)
+	}
+	defer f.Close()
+
+	var ifs []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		ifs = append(ifs, splitInterfaces(line)...)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read interfaces file %s: %w", path, err)
+	}
+	return ifs, nil
+}
+
+// resolveInterface looks up an interface by index or name
+func resolveInterface(s string) (*net.Interface, error) {
+	if idx, err := strconv.Atoi(s); err == nil {
+		return net.InterfaceByIndex(idx)
+	}
+	return net.InterfaceByName(s)
+}
+
+// addInterfaces makes the listed interfaces the ones handled regardless of the regex and adds those not handled yet.
+// taps of interfaces no longer listed are closed unless matching the regex. ones not resolving are logged and
+// skipped, so a partially provisioned host still starts
+func addInterfaces(e *radunnumbered.Engine, ifs []string) {
+	var listed []*net.Interface
+	for _, s := range ifs {
+		ifi, err := resolveInterface(s)
+		if err != nil {
+			ll.WithFields(ll.Fields{"Interface": s}).Warnf("skipping interface %s: %v", s, err)
+			continue
+		}
+		listed = append(listed, ifi)
+	}
+
+	idxs := make([]int, 0, len(listed))
+	for _, ifi := range listed {
+		idxs = append(idxs, ifi.Index)
+	}
+	e.SetListed(idxs)
+
+	for _, ifi := range listed {
+		if e.Exists(ifi.Index) {
+			continue
+		}
+		if err := e.Add(ifi.Index); errors.Is(err, radunnumbered.ErrNotQualified) {
+			ll.WithFields(ll.Fields{"Interface": ifi.Name}).Warnf("skipping interface %s, matching the exclude regex", ifi.Name)
+		}
+	}
+}
+
+// listedInterfaces returns the interfaces given by flag and file
+func listedInterfaces(list, path string) ([]string, error) {
+	ifs := splitInterfaces(list)
+	if path != "" {
+		fromFile, err := readInterfacesFile(path)
+		if err != nil {
+			return ifs, err
+		}
+		ifs = append(ifs, fromFile...)
+	}
+	return ifs, nil
+}
@@ -1,24 +1,73 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/linode/rad-unnumbered/radunnumbered"
 	ll "github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 var (
-	flagLifeTime = flag.Duration("lifetime", (30 * time.Minute), "Lifetime (prefix valid time will be 3x lifetime).")
-	flagInterval = flag.Duration("interval", (5 * time.Minute), "Frequency of *un*solicitated RAs.")
-	errRetry     = errors.New("retry")
-	exclude      IPNets
+	flagLifeTime        = flag.Duration("lifetime", (30 * time.Minute), "Lifetime (if given, prefix valid time will be 3x lifetime).")
+	flagInterval        = flag.Duration("interval", radunnumbered.DefaultMaxInterval, "Maximum time between *un*solicitated RAs.")
+	flagInitialRAs      = flag.Int("initial-advertisements", radunnumbered.DefaultInitialAdvertisements, "Number of RAs sent at most -initial-interval apart after a tap came up.")
+	flagInitialIntvl    = flag.Duration("initial-interval", 0, "Maximum time between the initial RAs. (0 = 16s or interval if shorter)")
+	flagMinInterval     = flag.Duration("min-interval", 0, "Minimum time between *un*solicitated RAs. (0 = 1/3 of interval)")
+	flagRouterLifeTime  = flag.Duration("router-lifetime", radunnumbered.DefaultRouterLifetime, "Router lifetime of the default route. (0 = not a default router, defaults to lifetime)")
+	flagMTU             = flag.Uint("mtu", 0, "MTU to advertise in RAs. (0 = use the interface MTU)")
+	flagManaged         = flag.Bool("managed", false, "Set the managed (M) flag, hosts should use DHCPv6 for addresses.")
+	flagOther           = flag.Bool("other", false, "Set the other config (O) flag, hosts should use DHCPv6 for other config.")
+	flagAutoOther       = flag.Bool("auto-other", false, "Set the other config (O) flag if no DNS servers or search domains are advertised.")
+	flagPreference      = flag.String("preference", "medium", "Default router preference. One of high, medium or low")
+	flagPriority        = flag.Int("priority", 0, "Default router priority, replaces -preference if given. (>0 = high, 0 = medium, <0 = low)")
+	flagSubnetRoutes    = flag.Bool("advertise-subnets", false, "Advertise subnet routes as route information options (RFC 4191).")
+	flagRoutePref       = flag.String("route-preference", "medium", "Preference of advertised subnet routes. One of high, medium or low")
+	flagRouteLifeTime   = flag.Duration("route-lifetime", radunnumbered.DefaultRouterLifetime, "Lifetime of advertised subnet routes.")
+	flagDefRouteLife    = flag.Duration("default-route-lifetime", 0, "Lifetime of an additional ::/0 route information option. (0 = not sent)")
+	flagDefRoutePref    = flag.String("default-route-preference", "low", "Preference of the ::/0 route information option. One of high, medium or low")
+	flagValidLifeTime   = flag.Duration("prefix-valid-lifetime", radunnumbered.DefaultPrefixValidLifetime, "Valid lifetime of advertised prefixes.")
+	flagPrefLifeTime    = flag.Duration("prefix-preferred-lifetime", radunnumbered.DefaultPrefixPreferredLifetime, "Preferred lifetime of advertised prefixes.")
+	flagULAOnLinkOnly   = flag.Bool("ula-onlink-only", false, "Advertise ULA prefixes on-link only, without SLAAC.")
+	flagHostRouteOnLink = flag.Bool("host-route-onlink", false, "Advertise prefixes derived from host routes only on-link as well.")
+	flagAnycastOffLink  = flag.Bool("anycast-offlink", false, "Don't advertise prefixes on-link whose subnet-router anycast address the tap holds.")
+	flagNAT64Prefix     = flag.String("nat64-prefix", "", "NAT64 prefix to advertise (PREF64), i.e. 64:ff9b::/96")
+	flagAdvInterval     = flag.Bool("adv-interval", false, "Advertise the max interval in the advertisement interval option (RFC 6275).")
+	flagCaptivePortal   = flag.String("captive-portal", "", "Captive portal URI to advertise (RFC 8910).")
+	flagHopLimit        = flag.Uint("hop-limit", radunnumbered.DefaultHopLimit, "Hop limit hosts should use. (0 = unspecified)")
+	flagReachableTime   = flag.Duration("reachable-time", 0, "Reachable time hosts should assume for neighbors. (0 = unspecified)")
+	flagRetransTimer    = flag.Duration("retransmit-timer", 0, "Time between retransmitted neighbor solicitations. (0 = unspecified)")
+	flagIncludeSLLA     = flag.Bool("slla", true, "Include the source link-layer address option in RAs.")
+	flagMode            = flag.String("mode", radunnumbered.ModeFullRouter, "One of full-router, addressing-only (no default route) or onlink-only (no default route, no SLAAC)")
+	flagMaxRSPerSource  = flag.Int("max-rs-per-source", 0, "Solicits answered per source address and minute, further ones are ignored. (0 = unlimited)")
+	flagForceMulticast  = flag.Bool("force-multicast", false, "Answer solicits via multicast only, never unicast.")
+	flagRespondToNS     = flag.Bool("respond-ns", false, "Answer neighbor solicitations for the link-local and held subnet-router anycast addresses.")
+	flagRespondToRS     = flag.Bool("respond-rs", true, "Answer router solicitations. (false = only send unsolicited RAs)")
+	flagDialTimeout     = flag.Duration("dial-timeout", radunnumbered.DefaultDialTimeout, "Give up on a tap which couldn't be dialed for that long.")
+	flagLogRAContents   = flag.Bool("log-ra-contents", false, "Log a summary of the options of every RA sent. (debug level)")
+	flagWaitForHost     = flag.Bool("wait-for-host", false, "Advertise prefixes only after a host on the tap sent a router or neighbor solicitation.")
+	flagObserve         = flag.Bool("observe", false, "Only log the router solicitations received, never send any RA.")
+	flagDryRun          = flag.Bool("dry-run", false, "Log the RAs that would be sent instead of sending them.")
+	exclude             IPNets
+	dnsServers          DNSServers
+	searchDomains       Strings
+	acceptICMP          Strings
+	prefixFlags         = PrefixFlagsMap{}
 )
 
+// shutdownTimeout limits how long we wait for all taps to close on SIGTERM/SIGINT
+const shutdownTimeout = 5 * time.Second
+
 var logLevels = map[string]func(){
 	"none":    func() { ll.SetOutput(ioutil.Discard) },
 	"trace":   func() { ll.SetLevel(ll.TraceLevel) },
@@ -60,16 +109,159 @@ func (i *IPNets) Set(value string) error {
 	return nil
 }
 
+// DNSServers parses addr or addr=lifetime dns servers
+type DNSServers []radunnumbered.DNSServer
+
+func (d *DNSServers) String() string {
+	var s string
+	for _, srv := range *d {
+		s = s + " " + srv.String()
+	}
+	return s
+}
+
+func (d *DNSServers) Set(value string) error {
+	var srv radunnumbered.DNSServer
+	if err := srv.UnmarshalText([]byte(value)); err != nil {
+		return err
+	}
+	*d = append(*d, srv)
+	return nil
+}
+
+type Strings []string
+
+func (s *Strings) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *Strings) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// PrefixFlagsMap parses prefix=flag,flag pairs where flag is one of onlink, autonomous or router=<address>
+type PrefixFlagsMap map[string]radunnumbered.PrefixFlags
+
+func (p PrefixFlagsMap) String() string {
+	var s string
+	for k, v := range p {
+		s = s + " " + fmt.Sprintf("%v=%+v", k, v)
+	}
+	return s
+}
+
+func (p PrefixFlagsMap) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("expected prefix=flags: %v", value)
+	}
+	ip, snet, err := net.ParseCIDR(kv[0])
+	if err != nil {
+		return fmt.Errorf("invalid prefix: %v", kv[0])
+	}
+	if l, _ := snet.Mask.Size(); ip.To4() != nil || l != 64 {
+		return fmt.Errorf("not a ipv6 /64 prefix: %v", kv[0])
+	}
+
+	var f radunnumbered.PrefixFlags
+	for _, flg := range strings.Split(kv[1], ",") {
+		switch flg {
+		case "onlink":
+			f.OnLink = true
+		case "autonomous":
+			f.Autonomous = true
+		case "":
+		default:
+			if a := strings.TrimPrefix(flg, "router="); a != flg {
+				if f.RouterAddress = net.ParseIP(a); f.RouterAddress == nil || !snet.Contains(f.RouterAddress) {
+					return fmt.Errorf("router address %q is not within prefix %v", a, snet)
+				}
+				continue
+			}
+			return fmt.Errorf("invalid prefix flag %q, must be onlink, autonomous or router=<address>", flg)
+		}
+	}
+	p[snet.String()] = f
+	return nil
+}
+
+// syncLinks adds all existing links which qualify and are ready but not handled yet
+func syncLinks(e *radunnumbered.Engine) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("unable to get current list of links: %v", err)
+	}
+
+	for _, link := range links {
+
+		ifName := link.Attrs().Name
+
+		if !e.Qualifies(ifName) {
+			ll.WithFields(ll.Fields{"Interface": ifName}).
+				Debugf("%s did not qualify, skipping...", ifName)
+			continue
+		}
+
+		if radunnumbered.LinkReady(link.Attrs()) && !e.Exists(link.Attrs().Index) {
+			e.Add(link.Attrs().Index)
+		}
+	}
+	return nil
+}
+
+// flagSet reports if the flag was explicitly given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func main() {
 	flagLogLevel := flag.String("loglevel", "info", fmt.Sprintf("Log level. One of %v", getLogLevels()))
+	flag.StringVar(flagLogLevel, "log-level", "info", "Alias for -loglevel.")
+	flagLogFormat := flag.String("log-format", "text", "Log format. One of text or json")
 	flagTapRegex := flag.String("regex", "tap.*_0", "regex to match interfaces.")
+	flagInterfaces := flag.String("interfaces", "", "Comma separated interface names or indices to handle even if not matching -regex, on top of the discovered ones.")
+	flagInterfacesFile := flag.String("interfaces-file", "", "File listing interface names or indices to handle even if not matching -regex, re-read on SIGHUP closing the taps no longer listed.")
+	flagExcludeRegex := flag.String("exclude-regex", "", "regex of interfaces not to handle, even if matching -regex.")
+	flagConfig := flag.String("config", "", "Path to yaml config file with defaults and per interface overrides.")
+	flagHTTPAddr := flag.String("http-addr", "", "Address to serve the control api and metrics on, i.e. unix:///run/radunnumbered.sock. (empty = disabled)")
+	flagMetricsAddr := flag.String("metrics-addr", "", "Deprecated alias for -http-addr.")
+	flagAPIAddr := flag.String("api-addr", "", "Deprecated alias for -http-addr.")
+	flagHTTPSocketMode := flag.String("http-socket-mode", "0600", "File mode of the -http-addr unix socket.")
+	flagStrictPrefixes := flag.Bool("strict-prefixes", false, "Refuse taps advertising the same prefix as an other tap instead of warning.")
+	flagPIDFile := flag.String("pidfile", "", "Write the pid to this file, refusing to start if another instance holds it. (empty = disabled)")
+	flagMaxTaps := flag.Int("max-taps", 4096, "Maximum number of taps handled at once.")
+	flagVersion := flag.Bool("version", false, "Print the version and exit.")
+	flagHealthMinTaps := flag.Int("healthz-min-taps", 1, "Number of taps sending RAs required for /healthz to succeed.")
 	flag.Var(&exclude, "exclude", "subnet to be excluded from slaac advertisments")
+	flag.Var(&dnsServers, "dns", "recursive dns server to be advertised (RDNSS) as addr[=lifetime], can be repeated")
+	flag.Var(&acceptICMP, "accept-icmp", "additional ICMPv6 type to receive, i.e. router-advertisement, can be repeated")
+	flag.Var(&searchDomains, "search", "dns search domain to be advertised (DNSSL), can be repeated")
+	flag.Var(prefixFlags, "prefix-flags", "override prefix flags as prefix=[onlink][,autonomous][,router=<address>], can be repeated")
 	flag.Parse()
 
-	ll.SetFormatter(&ll.TextFormatter{
-		FullTimestamp: true,
-		PadLevelText:  true,
-	})
+	if *flagVersion {
+		fmt.Printf("rad-unnumbered %s\n", radunnumbered.GetVersion())
+		os.Exit(0)
+	}
+
+	switch *flagLogFormat {
+	case "text":
+		ll.SetFormatter(&ll.TextFormatter{
+			FullTimestamp: true,
+			PadLevelText:  true,
+		})
+	case "json":
+		ll.SetFormatter(&ll.JSONFormatter{})
+	default:
+		ll.Fatalf("Invalid log format '%s'. Valid log formats are text or json", *flagLogFormat)
+	}
 
 	setlvl, ok := logLevels[*flagLogLevel]
 	if !ok {
@@ -77,10 +269,36 @@ func main() {
 	}
 	setlvl()
 
-	ll.Infoln("starting up...")
+	ll.Infof("starting up rad-unnumbered %s...", radunnumbered.GetVersion())
+
+	var pid *pidFile
+	if *flagPIDFile != "" {
+		p, err := acquirePIDFile(*flagPIDFile)
+		if err != nil {
+			ll.Fatalf("%v", err)
+		}
+		pid = p
+	}
 	ll.Infof("Loglevel '%s'", ll.GetLevel())
-	ll.Infof("Sending RAs valid for %v every %v on interfaces matching %s", *flagLifeTime, *flagInterval, *flagTapRegex)
+	ll.Infof(
+		"Sending RAs valid for %v at most every %v on interfaces matching %s",
+		*flagLifeTime,
+		*flagInterval,
+		*flagTapRegex,
+	)
 	ll.Infof("Excluding %s from RAs", exclude)
+	if *flagDryRun {
+		ll.Warnln("dry-run mode, RAs are logged but not sent")
+	}
+	if *flagObserve {
+		ll.Warnln("observe mode, solicits are logged but no RAs sent")
+	}
+	if len(dnsServers) > 0 {
+		ll.Infof("Advertising DNS servers %s", dnsServers.String())
+	}
+	if len(searchDomains) > 0 {
+		ll.Infof("Advertising DNS search domains %s", searchDomains.String())
+	}
 
 	if flagLifeTime.Seconds() < 3*(flagInterval.Seconds()) {
 		ll.Warnf(
@@ -90,6 +308,20 @@ func main() {
 		)
 	}
 
+	// the api and metrics used to have their own listeners, both are served on -http-addr now
+	httpAddr := *flagHTTPAddr
+	for _, alias := range []struct{ name, addr string }{{"api-addr", *flagAPIAddr}, {"metrics-addr", *flagMetricsAddr}} {
+		if alias.addr == "" {
+			continue
+		}
+		ll.Warnf("-%s is deprecated, use -http-addr instead", alias.name)
+		if httpAddr == "" {
+			httpAddr = alias.addr
+		} else if httpAddr != alias.addr {
+			ll.Fatalf("-%s %s conflicts with %s, the control api and metrics share a single listener", alias.name, alias.addr, httpAddr)
+		}
+	}
+
 	linksFeed := make(chan netlink.LinkUpdate, 10)
 	linksDone := make(chan struct{})
 
@@ -99,38 +331,215 @@ func main() {
 		ll.Fatalf("unable to open netlink feed: %v", err)
 	}
 
-	// get existing list of links, in case we startup when vms are already active
-	t, err := netlink.LinkList()
+	routesFeed := make(chan netlink.RouteUpdate, 10)
+	routesDone := make(chan struct{})
+
+	// route changes tell us when a vm got (or lost) its ipv6 range after the tap came up
+	err = netlink.RouteSubscribe(routesFeed, routesDone)
 	if err != nil {
-		ll.Fatalf("unable to get current list of links: %v", err)
+		ll.Fatalf("unable to open netlink route feed: %v", err)
 	}
 
-	e, err := NewEngine(*flagTapRegex)
-	if err != nil {
-		ll.Fatalf("unable to get started: %v", err)
+	// router lifetime follows the generic lifetime unless explicitly given
+	routerLifetime := flagLifeTime
+	if flagSet("router-lifetime") {
+		routerLifetime = flagRouterLifeTime
 	}
 
-	// when starting up making sure any already existing interfaces are being handled and started
-	for _, link := range t {
+	// prefix lifetimes are derived from the generic lifetime if that was given, otherwise the RFC defaults apply
+	validLifetime, preferredLifetime := *flagValidLifeTime, *flagPrefLifeTime
+	if flagSet("lifetime") {
+		if !flagSet("prefix-valid-lifetime") {
+			validLifetime = 3 * *flagLifeTime
+		}
+		if !flagSet("prefix-preferred-lifetime") {
+			preferredLifetime = *flagLifeTime
+		}
+	}
 
-		ifName := link.Attrs().Name
+	if *flagHopLimit > 255 {
+		ll.Fatalf("hop limit %d out of range, must be 0-255", *flagHopLimit)
+	}
+	hopLimit := uint8(*flagHopLimit)
 
-		if !e.Qualifies(ifName) {
-			ll.WithFields(ll.Fields{"Interface": ifName}).
-				Debugf("%s did not qualify, skipping...", ifName)
-			continue
+	var priority *int
+	if flagSet("priority") {
+		priority = flagPriority
+	}
+
+	var nat64Prefix *net.IPNet
+	if *flagNAT64Prefix != "" {
+		_, nat64Prefix, err = net.ParseCIDR(*flagNAT64Prefix)
+		if err != nil {
+			ll.Fatalf("invalid nat64 prefix: %v", *flagNAT64Prefix)
 		}
+	}
 
-		if linkReady(link.Attrs()) {
-			e.Add(link.Attrs().Index)
+	baseConfig := radunnumbered.TapConfig{
+		MinInterval:             *flagMinInterval,
+		MaxInterval:             *flagInterval,
+		InitialAdvertisements:   *flagInitialRAs,
+		InitialAdvertInterval:   *flagInitialIntvl,
+		MTU:                     uint32(*flagMTU),
+		DNSServers:              dnsServers,
+		SearchDomains:           searchDomains,
+		NAT64Prefix:             nat64Prefix,
+		CaptivePortalURI:        *flagCaptivePortal,
+		AdvInterval:             *flagAdvInterval,
+		RouterLifetime:          routerLifetime,
+		HopLimit:                &hopLimit,
+		ReachableTime:           *flagReachableTime,
+		RetransmitTimer:         *flagRetransTimer,
+		ManagedFlag:             *flagManaged,
+		OtherFlag:               *flagOther,
+		AutoOtherFlag:           *flagAutoOther,
+		Preference:              *flagPreference,
+		Priority:                priority,
+		AdvertiseSubnetRoutes:   *flagSubnetRoutes,
+		RoutePreference:         *flagRoutePref,
+		RouteLifetime:           *flagRouteLifeTime,
+		DefaultRouteLifetime:    *flagDefRouteLife,
+		DefaultRoutePreference:  *flagDefRoutePref,
+		PrefixFlags:             prefixFlags,
+		ULAOnLinkOnly:           *flagULAOnLinkOnly,
+		SuppressAnycastOnLink:   *flagAnycastOffLink,
+		HostRouteOnLink:         *flagHostRouteOnLink,
+		PrefixValidLifetime:     validLifetime,
+		PrefixPreferredLifetime: preferredLifetime,
+		IncludeSLLA:             flagIncludeSLLA,
+		RespondToRS:             flagRespondToRS,
+		ForceMulticastResponse:  *flagForceMulticast,
+		MaxRSPerSource:          *flagMaxRSPerSource,
+		RespondToNS:             *flagRespondToNS,
+		AcceptICMP:              acceptICMP,
+		Mode:                    *flagMode,
+		ExcludeSubnets:          exclude,
+		DryRun:                  *flagDryRun,
+		LogRAContents:           *flagLogRAContents,
+		Observe:                 *flagObserve,
+		WaitForHost:             *flagWaitForHost,
+		DialTimeout:             *flagDialTimeout,
+	}
+	tapConfig, tapRegex, err := radunnumbered.ResolveConfig(*flagConfig, baseConfig, *flagTapRegex)
+	if err != nil {
+		ll.Fatalf("invalid configuration: %v", err)
+	}
+
+	e, err := radunnumbered.NewEngine(
+		tapRegex,
+		tapConfig,
+		radunnumbered.WithMaxTaps(*flagMaxTaps),
+		radunnumbered.WithStrictPrefixes(*flagStrictPrefixes),
+		radunnumbered.WithExclude(*flagExcludeRegex),
+	)
+	if err != nil {
+		ll.Fatalf("unable to get started: %v", err)
+	}
+
+	closeHTTP := func() {}
+	// stays nil without -http-addr, never firing in the select below
+	var httpFailed <-chan error
+	if httpAddr != "" {
+		mode, err := strconv.ParseUint(*flagHTTPSocketMode, 8, 32)
+		if err != nil {
+			ll.Fatalf("invalid http socket mode: %v", *flagHTTPSocketMode)
+		}
+		closeHTTP, httpFailed, err = radunnumbered.ServeHTTP(httpAddr, os.FileMode(mode), e, *flagHealthMinTaps)
+		if err != nil {
+			ll.Fatalf("unable to serve http on %s: %v", httpAddr, err)
 		}
 	}
 
+	// when starting up making sure any already existing interfaces are being handled and started
+	if err := syncLinks(e); err != nil {
+		ll.Fatalf("%v", err)
+	}
+	ifs, err := listedInterfaces(*flagInterfaces, *flagInterfacesFile)
+	if err != nil {
+		ll.Fatalf("%v", err)
+	}
+	addInterfaces(e, ifs)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 2)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
 	// as we go on, detect any NIC changes from netlink and act accordingly
 	for {
 		select {
 		case <-linksDone:
 			ll.Fatalln("netlink feed ended")
+		case err := <-httpFailed:
+			// still taking the taps down properly, hosts get their final RAs and the pid file is released
+			ll.Errorf("%v, shutting down...", err)
+			e.Shutdown(shutdownTimeout)
+			closeHTTP()
+			pid.release()
+			os.Exit(1)
+		case sig := <-stop:
+			ll.Infof("%v received, shutting down...", sig)
+			go func() {
+				<-stop
+				ll.Warnln("second signal received, exiting immediately")
+				closeHTTP()
+				pid.release()
+				os.Exit(1)
+			}()
+			err := e.Shutdown(shutdownTimeout)
+			closeHTTP()
+			pid.release()
+			if err != nil {
+				ll.Errorf("%v", err)
+				os.Exit(1)
+			}
+			ll.Infoln("all taps closed, bye")
+			os.Exit(0)
+		case <-hup:
+			ll.Infof("SIGHUP received, reloading configuration")
+			cfg, regex, err := radunnumbered.ResolveConfig(*flagConfig, baseConfig, *flagTapRegex)
+			if err != nil {
+				ll.Errorf("keeping current configuration, reload failed: %v", err)
+				continue
+			}
+			if err := e.Reload(regex, cfg); err != nil {
+				ll.Errorf("keeping current configuration, reload failed: %v", err)
+				continue
+			}
+			if err := syncLinks(e); err != nil {
+				ll.Errorf("%v", err)
+			}
+			ifs, err := listedInterfaces(*flagInterfaces, *flagInterfacesFile)
+			if err != nil {
+				// a partial list would close the taps missing from it
+				ll.Errorf("keeping the listed interfaces: %v", err)
+				continue
+			}
+			addInterfaces(e, ifs)
+		case <-routesDone:
+			ll.Fatalln("netlink route feed ended")
+		case route := <-routesFeed:
+			if route.Dst == nil || route.Dst.IP.To4() != nil {
+				continue
+			}
+			ll.WithFields(ll.Fields{"InterfaceID": route.LinkIndex}).
+				Tracef("Netlink route fired: %v %s on %d", route.Type, route.Dst, route.LinkIndex)
+			if e.Exists(route.LinkIndex) {
+				e.RefreshRoutes(route.LinkIndex)
+				continue
+			}
+
+			// taps without any route are ignored on creation, so the first route may make it qualify
+			if route.Type != unix.RTM_NEWROUTE {
+				continue
+			}
+			link, err := netlink.LinkByIndex(route.LinkIndex)
+			if err != nil {
+				continue
+			}
+			if (e.Qualifies(link.Attrs().Name) || e.Listed(route.LinkIndex)) && radunnumbered.LinkReady(link.Attrs()) {
+				e.Add(route.LinkIndex)
+			}
 		case link := <-linksFeed:
 			linkAttrs := link.Attrs()
 			if linkAttrs == nil {
@@ -141,7 +550,8 @@ func main() {
 			ifName := linkAttrs.Name
 			tapState := linkAttrs.OperState
 
-			if !e.Qualifies(ifName) {
+			// listed taps may not match the regex, their link changes still matter
+			if !e.Qualifies(ifName) && !e.Listed(linkAttrs.Index) {
 				ll.WithFields(ll.Fields{"Interface": ifName}).
 					Debugf("%s did not qualify, skipping...", ifName)
 				continue
@@ -156,12 +566,19 @@ func main() {
 
 			tapExists := e.Exists(linkAttrs.Index)
 
-			if !tapExists && linkReady(linkAttrs) {
+			if link.Header.Type == unix.RTM_DELLINK {
+				// interface is gone for good, no point in checking its state
+				if tapExists {
+					e.Close(linkAttrs.Index)
+				}
+			} else if !tapExists && radunnumbered.LinkReady(linkAttrs) {
 				e.Add(linkAttrs.Index)
-			} else if tapExists && !linkReady(linkAttrs) {
-				e.Close(linkAttrs.Index)
+			} else if tapExists {
+				// a link going down is likely coming back, so only pausing instead of tearing the tap down
+				e.SetLinkUp(linkAttrs.Index, radunnumbered.LinkUp(linkAttrs))
 			} else {
-				ll.Tracef("%s Exists: %v, OperState: %s ... nothing to do?", ifName, tapExists, tapState)
+				ll.WithFields(ll.Fields{"Interface": ifName}).
+					Tracef("%s Exists: %v, OperState: %s ... nothing to do?", ifName, tapExists, tapState)
 			}
 		}
 	}
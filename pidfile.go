@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// pidFile is the locked pid file of the running instance
+type pidFile struct {
+	path string
+	f    *os.File
+}
+
+// acquirePIDFile writes our pid to path, holding an exclusive lock on it as long as we run.
+// it fails if another instance holds the lock, since two of us would answer every RS twice
+func acquirePIDFile(path string) (*pidFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open pid file: %w", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			return nil, fmt.Errorf("pid file %s is locked, another instance is running already", path)
+		}
+		return nil, fmt.Errorf("unable to lock pid file: %w", err)
+	}
+
+	// the lock is ours, so whatever is in there is stale
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write pid file: %w", err)
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("unable to write pid file: %w", err)
+	}
+	return &pidFile{path: path, f: f}, nil
+}
+
+// release removes the pid file and drops the lock, nil safe
+func (p *pidFile) release() {
+	if p == nil {
+		return
+	}
+	// removing before unlocking, so a starting instance can't lock the file just to see it vanish
+	os.Remove(p.path)
+	p.f.Close()
+}
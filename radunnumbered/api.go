@@ -0,0 +1,488 @@
+package radunnumbered
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	ll "github.com/sirupsen/logrus"
+)
+
+// tapInfo is the json representation of a handled tap
+type tapInfo struct {
+	Index int    `json:"index"`
+	Name  string `json:"name"`
+	MAC   string `json:"mac"`
+	// Source is the link-local address RAs are sent from, empty until listening
+	Source   string       `json:"source"`
+	Prefixes []prefixInfo `json:"prefixes"`
+	Subnets  []string     `json:"subnets"`
+	// RouterPreference applies to the default route (RA header), RoutePreference to the subnet routes only
+	RouterPreference string `json:"router_preference"`
+	RoutePreference  string `json:"route_preference"`
+	// Priority the router preference is derived from, if given
+	Priority *int `json:"priority,omitempty"`
+	Draining bool `json:"draining"`
+	// WaitingForHost is set while prefixes are held back until a host on the tap solicited
+	WaitingForHost bool `json:"waiting_for_host"`
+}
+
+// prefixInfo is the json representation of an advertised prefix
+type prefixInfo struct {
+	Prefix string `json:"prefix"`
+	Class  string `json:"class"`
+	// Deprecated is set if the prefix got deprecated via /taps/{ifindex}/deprecate
+	Deprecated        bool         `json:"deprecated"`
+	OnLink            bool         `json:"on_link"`
+	Autonomous        bool         `json:"autonomous"`
+	ValidLifetime     string       `json:"valid_lifetime"`
+	PreferredLifetime string       `json:"preferred_lifetime"`
+	Source            PrefixSource `json:"source"`
+}
+
+// lastErrorInfo is the json body of /taps/{ifindex}/last-error
+type lastErrorInfo struct {
+	Name   string    `json:"name"`
+	Error  string    `json:"error"`
+	Closed time.Time `json:"closed"`
+}
+
+// dnsInfo is the json response of a dns server update
+type dnsInfo struct {
+	Previous []string `json:"previous"`
+}
+
+// healthInfo is the json body of /healthz
+type healthInfo struct {
+	Healthy int      `json:"healthy"`
+	Stale   []string `json:"stale"`
+}
+
+// stateInfo is the json body of /debug/state
+type stateInfo struct {
+	TapCount int            `json:"tap_count"`
+	Taps     []tapStateInfo `json:"taps"`
+}
+
+// tapStateInfo is the debug state of a single tap
+type tapStateInfo struct {
+	Name       string    `json:"name"`
+	Prefixes   []string  `json:"prefixes"`
+	Deprecated []string  `json:"deprecated"`
+	Subnets    []string  `json:"subnets"`
+	LastRA     time.Time `json:"last_ra"`
+	RSCount    int64     `json:"rs_count"`
+}
+
+// api is the http control interface to the engine
+type api struct {
+	e *Engine
+	// minTaps is the number of healthy taps expected for /healthz to succeed
+	minTaps int
+}
+
+// ServeHTTP serves the control api and the metrics on a single listener. addr is either a tcp address or a unix socket
+// as unix:///path, which gets created with mode. the returned func stops serving and removes the socket, the channel
+// gets the error should serving fail later on, so the caller can shut down properly
+func ServeHTTP(addr string, mode os.FileMode, e *Engine, minTaps int) (func(), <-chan error, error) {
+	mux := http.NewServeMux()
+	(&api{e: e, minTaps: minTaps}).register(mux)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	l, err := listen(addr, mode)
+	if err != nil {
+		return nil, nil, err
+	}
+	srv := &http.Server{Handler: mux}
+	ll.Infof("serving control api and metrics on %s", addr)
+	failed := make(chan error, 1)
+	go func() {
+		if err := srv.Serve(l); err != http.ErrServerClosed {
+			failed <- fmt.Errorf("http server failed: %w", err)
+		}
+	}()
+	// closing the unix listener unlinks the socket as well
+	return func() { srv.Close() }, failed, nil
+}
+
+// listen opens a tcp listener, or a unix socket with the given file mode if addr is unix:///path.
+// a socket left behind by a previous run is replaced
+func listen(addr string, mode os.FileMode) (net.Listener, error) {
+	path := strings.TrimPrefix(addr, "unix://")
+	if path == addr {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %v", path, err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("unable to set mode of socket %s: %v", path, err)
+	}
+	return l, nil
+}
+
+// register adds the api endpoints to mux
+func (a *api) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/version", a.handleVersion)
+	mux.HandleFunc("/debug/state", a.handleDebugState)
+	mux.HandleFunc("/taps", a.handleTaps)
+	mux.HandleFunc("/taps/", a.handleTap)
+}
+
+// handleVersion returns the version, commit and build date of the daemon
+func (a *api) handleVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, GetVersion())
+}
+
+// handleTaps lists all taps currently handled by the engine
+func (a *api) handleTaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	a.e.lock.RLock()
+	taps := make([]tapInfo, 0, len(a.e.tap))
+	for idx, t := range a.e.tap {
+		info := tapInfo{
+			Index:    idx,
+			Name:     t.Ifi.Name,
+			MAC:      t.HardwareAddr().String(),
+			Prefixes: []prefixInfo{},
+			Subnets:  []string{},
+		}
+		t.lock.RLock()
+		retired := t.retired
+		advertised := t.advertisedPrefixes()
+		t.lock.RUnlock()
+		for _, p := range advertised {
+			// prefixes that lost their route are listed in /debug/state
+			if p.Source == PrefixSourceDeprecated {
+				continue
+			}
+			info.Prefixes = append(info.Prefixes, prefixInfo{
+				Prefix:            fmt.Sprintf("%s/%d", p.Prefix, p.Length),
+				Class:             classifyPrefix(p.Prefix),
+				Deprecated:        containsIP(retired, p.Prefix),
+				OnLink:            p.OnLink,
+				Autonomous:        p.Autonomous,
+				ValidLifetime:     p.ValidLifetime.String(),
+				PreferredLifetime: p.PreferredLifetime.String(),
+				Source:            p.Source,
+			})
+		}
+		for _, s := range t.Subnets() {
+			info.Subnets = append(info.Subnets, s.String())
+		}
+		if src := t.Source(); src != nil {
+			info.Source = src.String()
+		}
+		t.lock.RLock()
+		info.RouterPreference = strings.ToLower(t.Preference.String())
+		info.RoutePreference = strings.ToLower(t.RoutePreference.String())
+		info.Priority = t.Priority
+		info.Draining = t.draining
+		info.WaitingForHost = t.WaitForHost && !t.hostSeen
+		t.lock.RUnlock()
+		taps = append(taps, info)
+	}
+	a.e.lock.RUnlock()
+
+	sort.Slice(taps, func(i, j int) bool { return taps[i].Index < taps[j].Index })
+	writeJSON(w, http.StatusOK, taps)
+}
+
+// handleHealthz succeeds if enough taps sent an RA within twice their max interval and none is stale
+func (a *api) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	health := healthInfo{Stale: []string{}}
+
+	a.e.lock.RLock()
+	for _, t := range a.e.tap {
+		if t.Healthy() {
+			health.Healthy++
+		} else {
+			health.Stale = append(health.Stale, t.Ifi.Name)
+		}
+	}
+	a.e.lock.RUnlock()
+
+	sort.Strings(health.Stale)
+	status := http.StatusOK
+	if health.Healthy < a.minTaps || len(health.Stale) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, health)
+}
+
+// handleDebugState dumps the engine state for debugging on the box
+func (a *api) handleDebugState(w http.ResponseWriter, r *http.Request) {
+	state := stateInfo{Taps: []tapStateInfo{}}
+
+	a.e.lock.RLock()
+	state.TapCount = len(a.e.tap)
+	for _, t := range a.e.tap {
+		prefixes, deprecated, subnets := t.routes.get()
+		info := tapStateInfo{
+			Name:       t.Ifi.Name,
+			Prefixes:   []string{},
+			Deprecated: []string{},
+			Subnets:    []string{},
+			LastRA:     time.Unix(0, atomic.LoadInt64(&t.lastRA)),
+			RSCount:    atomic.LoadInt64(&t.rsCount),
+		}
+		for _, p := range prefixes {
+			info.Prefixes = append(info.Prefixes, p.String()+"/64")
+		}
+		for _, p := range deprecated {
+			info.Deprecated = append(info.Deprecated, p.String()+"/64")
+		}
+		for _, s := range subnets {
+			info.Subnets = append(info.Subnets, s.String())
+		}
+		state.Taps = append(state.Taps, info)
+	}
+	a.e.lock.RUnlock()
+
+	sort.Slice(state.Taps, func(i, j int) bool { return state.Taps[i].Name < state.Taps[j].Name })
+	writeJSON(w, http.StatusOK, state)
+}
+
+// handleTap adds (POST) or removes (DELETE) the tap given by its ifindex: /taps/{ifindex}
+func (a *api) handleTap(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/taps/")
+	if strings.HasSuffix(path, "/ra.hex") {
+		a.handleRAHex(w, r, strings.TrimSuffix(path, "/ra.hex"))
+		return
+	}
+	if strings.HasSuffix(path, "/drain") {
+		a.handleDrain(w, r, strings.TrimSuffix(path, "/drain"))
+		return
+	}
+	if strings.HasSuffix(path, "/deprecate") {
+		a.handleDeprecate(w, r, strings.TrimSuffix(path, "/deprecate"))
+		return
+	}
+	if strings.HasSuffix(path, "/last-error") {
+		a.handleLastError(w, r, strings.TrimSuffix(path, "/last-error"))
+		return
+	}
+	if strings.HasSuffix(path, "/dns") {
+		a.handleDNS(w, r, strings.TrimSuffix(path, "/dns"))
+		return
+	}
+
+	ifIdx, err := strconv.Atoi(path)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if _, err := net.InterfaceByIndex(ifIdx); err != nil {
+			http.Error(w, fmt.Sprintf("unable to get interface: %v", err), http.StatusBadRequest)
+			return
+		}
+		if a.e.Exists(ifIdx) {
+			http.Error(w, fmt.Sprintf("tap %d already exists", ifIdx), http.StatusConflict)
+			return
+		}
+		if err := a.e.Add(ifIdx); errors.Is(err, ErrTapExists) {
+			http.Error(w, fmt.Sprintf("tap %d already exists", ifIdx), http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrNotQualified) {
+			http.Error(w, fmt.Sprintf("tap %d does not qualify", ifIdx), http.StatusForbidden)
+			return
+		} else if errors.Is(err, ErrPrefixConflict) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if errors.Is(err, ErrTooManyTaps) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if !a.e.Exists(ifIdx) {
+			http.Error(w, fmt.Sprintf("tap %d not found", ifIdx), http.StatusNotFound)
+			return
+		}
+		a.e.Close(ifIdx)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDrain starts (POST) or stops (DELETE) draining the tap: /taps/{ifindex}/drain
+func (a *api) handleDrain(w http.ResponseWriter, r *http.Request, idx string) {
+	ifIdx, err := strconv.Atoi(idx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+	t := a.e.Get(ifIdx)
+	if t == nil {
+		http.Error(w, fmt.Sprintf("tap %d not found", ifIdx), http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		t.SetDraining(true)
+	case http.MethodDelete:
+		t.SetDraining(false)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLastError returns why the tap failed, if it did recently: /taps/{ifindex}/last-error
+func (a *api) handleLastError(w http.ResponseWriter, r *http.Request, idx string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ifIdx, err := strconv.Atoi(idx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+	te, ok := a.e.LastError(ifIdx)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no recent error for tap %d", ifIdx), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, lastErrorInfo{Name: te.Name, Error: te.Err.Error(), Closed: te.Closed})
+}
+
+// handleDNS replaces (PUT) the dns servers of the tap with a json list of addresses (addr or addr=lifetime),
+// the previous ones are returned: /taps/{ifindex}/dns
+func (a *api) handleDNS(w http.ResponseWriter, r *http.Request, idx string) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ifIdx, err := strconv.Atoi(idx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+	t := a.e.Get(ifIdx)
+	if t == nil {
+		http.Error(w, fmt.Sprintf("tap %d not found", ifIdx), http.StatusNotFound)
+		return
+	}
+
+	var servers []DNSServer
+	if err := json.NewDecoder(r.Body).Decode(&servers); err != nil {
+		http.Error(w, fmt.Sprintf("invalid dns servers: %v", err), http.StatusBadRequest)
+		return
+	}
+	prev, err := t.SetDNSServers(servers)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	previous := []string{}
+	for _, d := range prev {
+		previous = append(previous, d.String())
+	}
+	writeJSON(w, http.StatusOK, dnsInfo{Previous: previous})
+}
+
+// handleDeprecate deprecates (POST) or un-deprecates (DELETE) a single prefix of the tap, given as query parameter:
+// /taps/{ifindex}/deprecate?prefix=2001:db8::/64
+func (a *api) handleDeprecate(w http.ResponseWriter, r *http.Request, idx string) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ifIdx, err := strconv.Atoi(idx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+	t := a.e.Get(ifIdx)
+	if t == nil {
+		http.Error(w, fmt.Sprintf("tap %d not found", ifIdx), http.StatusNotFound)
+		return
+	}
+	_, prefix, err := net.ParseCIDR(r.URL.Query().Get("prefix"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid prefix: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := t.SetPrefixDeprecated(prefix, r.Method == http.MethodPost); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRAHex returns the RA the tap would send right now, marshaled the same way as on the wire and hex encoded:
+// /taps/{ifindex}/ra.hex
+func (a *api) handleRAHex(w http.ResponseWriter, r *http.Request, idx string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ifIdx, err := strconv.Atoi(idx)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ifindex: %v", err), http.StatusBadRequest)
+		return
+	}
+	t := a.e.Get(ifIdx)
+	if t == nil {
+		http.Error(w, fmt.Sprintf("tap %d not found", ifIdx), http.StatusNotFound)
+		return
+	}
+
+	t.lock.RLock()
+	m := t.advertisement()
+	t.lock.RUnlock()
+	b, err := ndp.MarshalMessage(m)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("unable to marshal RA: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, hex.EncodeToString(b))
+}
+
+// writeJSON sends v json encoded with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		ll.Warnf("failed writing api response: %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package radunnumbered
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	ll "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the layout of the yaml configuration file
+type Config struct {
+	// Regex overrides the -regex flag selecting the interfaces to handle
+	Regex string `yaml:"regex"`
+	// Defaults apply to all taps, on top of the command line flags
+	Defaults InterfaceConfig `yaml:"defaults"`
+	// Interfaces are overrides per interface name, on top of the defaults
+	Interfaces map[string]InterfaceConfig `yaml:"interfaces"`
+}
+
+// InterfaceConfig is a set of RA settings in the config file, unset values are inherited
+type InterfaceConfig struct {
+	MinInterval             *time.Duration `yaml:"min_interval"`
+	MaxInterval             *time.Duration `yaml:"max_interval"`
+	InitialAdvertisements   *int           `yaml:"initial_advertisements"`
+	InitialAdvertInterval   *time.Duration `yaml:"initial_interval"`
+	MTU                     *uint32        `yaml:"mtu"`
+	DNSServers              []DNSServer    `yaml:"dns_servers"`
+	SearchDomains           []string       `yaml:"search_domains"`
+	NAT64Prefix             *CIDR          `yaml:"nat64_prefix"`
+	CaptivePortalURI        *string        `yaml:"captive_portal"`
+	AdvInterval             *bool          `yaml:"adv_interval"`
+	RouterLifetime          *time.Duration `yaml:"router_lifetime"`
+	HopLimit                *uint8         `yaml:"hop_limit"`
+	ReachableTime           *time.Duration `yaml:"reachable_time"`
+	RetransmitTimer         *time.Duration `yaml:"retransmit_timer"`
+	ManagedFlag             *bool          `yaml:"managed"`
+	OtherFlag               *bool          `yaml:"other"`
+	AutoOtherFlag           *bool          `yaml:"auto_other"`
+	Preference              *string        `yaml:"preference"`
+	Priority                *int           `yaml:"priority"`
+	AdvertiseSubnetRoutes   *bool          `yaml:"advertise_subnets"`
+	RoutePreference         *string        `yaml:"route_preference"`
+	RouteLifetime           *time.Duration `yaml:"route_lifetime"`
+	DefaultRouteLifetime    *time.Duration `yaml:"default_route_lifetime"`
+	DefaultRoutePreference  *string        `yaml:"default_route_preference"`
+	ULAOnLinkOnly           *bool          `yaml:"ula_onlink_only"`
+	SuppressAnycastOnLink   *bool          `yaml:"suppress_anycast_onlink"`
+	HostRouteOnLink         *bool          `yaml:"host_route_onlink"`
+	PrefixValidLifetime     *time.Duration `yaml:"prefix_valid_lifetime"`
+	PrefixPreferredLifetime *time.Duration `yaml:"prefix_preferred_lifetime"`
+	StaticPrefix            *CIDR          `yaml:"static_prefix"`
+	IncludeSLLA             *bool          `yaml:"include_slla"`
+	RespondToRS             *bool          `yaml:"respond_to_rs"`
+	ForceMulticastResponse  *bool          `yaml:"force_multicast_response"`
+	MaxRSPerSource          *int           `yaml:"max_rs_per_source"`
+	RespondToNS             *bool          `yaml:"respond_to_ns"`
+	SourceLinkLocal         net.IP         `yaml:"source_link_local"`
+	UnicastPeriodicTo       net.IP         `yaml:"unicast_periodic_to"`
+	AcceptICMP              []string       `yaml:"accept_icmp"`
+	DialTimeout             *time.Duration `yaml:"dial_timeout"`
+	Observe                 *bool          `yaml:"observe"`
+	WaitForHost             *bool          `yaml:"wait_for_host"`
+	Mode                    *string        `yaml:"mode"`
+}
+
+// CIDR is a subnet given as string in the config file, i.e. 64:ff9b::/96
+type CIDR struct {
+	net.IPNet
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (c *CIDR) UnmarshalText(b []byte) error {
+	_, n, err := net.ParseCIDR(string(b))
+	if err != nil {
+		return fmt.Errorf("invalid subnet: %s", b)
+	}
+	c.IPNet = *n
+	return nil
+}
+
+// LoadConfig reads and parses the yaml config file, unknown keys are rejected
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %w", err)
+	}
+
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("unable to parse config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// TapConfig merges the config file over base, the result is used for all taps of the engine
+func (c *Config) TapConfig(base TapConfig) TapConfig {
+	cfg := c.Defaults.merge(base)
+	cfg.Interfaces = c.Interfaces
+	return cfg
+}
+
+// ResolveConfig loads the config file at path (if any) over the base config from the flags and validates the result.
+// it returns the tap config and interface regex to be used by the engine
+func ResolveConfig(path string, base TapConfig, regex string) (TapConfig, string, error) {
+	cfg := base
+	if path != "" {
+		conf, err := LoadConfig(path)
+		if err != nil {
+			return cfg, regex, err
+		}
+		cfg = conf.TapConfig(base)
+		if conf.Regex != "" {
+			regex = conf.Regex
+		}
+		ll.Infof("Loaded config %s with %d interface overrides", path, len(cfg.Interfaces))
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, regex, err
+	}
+	return cfg, regex, nil
+}
+
+// merge returns cfg with all values set in ic replaced
+func (ic InterfaceConfig) merge(cfg TapConfig) TapConfig {
+	if ic.MinInterval != nil {
+		cfg.MinInterval = *ic.MinInterval
+	}
+	if ic.MaxInterval != nil {
+		cfg.MaxInterval = *ic.MaxInterval
+	}
+	if ic.InitialAdvertisements != nil {
+		cfg.InitialAdvertisements = *ic.InitialAdvertisements
+	}
+	if ic.InitialAdvertInterval != nil {
+		cfg.InitialAdvertInterval = *ic.InitialAdvertInterval
+	}
+	if ic.MTU != nil {
+		cfg.MTU = *ic.MTU
+	}
+	if ic.DNSServers != nil {
+		cfg.DNSServers = ic.DNSServers
+	}
+	if ic.SearchDomains != nil {
+		cfg.SearchDomains = ic.SearchDomains
+	}
+	if ic.NAT64Prefix != nil {
+		cfg.NAT64Prefix = &ic.NAT64Prefix.IPNet
+	}
+	if ic.CaptivePortalURI != nil {
+		cfg.CaptivePortalURI = *ic.CaptivePortalURI
+	}
+	if ic.AdvInterval != nil {
+		cfg.AdvInterval = *ic.AdvInterval
+	}
+	if ic.RouterLifetime != nil {
+		cfg.RouterLifetime = ic.RouterLifetime
+	}
+	if ic.HopLimit != nil {
+		cfg.HopLimit = ic.HopLimit
+	}
+	if ic.ReachableTime != nil {
+		cfg.ReachableTime = *ic.ReachableTime
+	}
+	if ic.RetransmitTimer != nil {
+		cfg.RetransmitTimer = *ic.RetransmitTimer
+	}
+	if ic.ManagedFlag != nil {
+		cfg.ManagedFlag = *ic.ManagedFlag
+	}
+	if ic.OtherFlag != nil {
+		cfg.OtherFlag = *ic.OtherFlag
+	}
+	if ic.AutoOtherFlag != nil {
+		cfg.AutoOtherFlag = *ic.AutoOtherFlag
+	}
+	if ic.Preference != nil {
+		cfg.Preference = *ic.Preference
+	}
+	if ic.Priority != nil {
+		cfg.Priority = ic.Priority
+	}
+	if ic.AdvertiseSubnetRoutes != nil {
+		cfg.AdvertiseSubnetRoutes = *ic.AdvertiseSubnetRoutes
+	}
+	if ic.RoutePreference != nil {
+		cfg.RoutePreference = *ic.RoutePreference
+	}
+	if ic.RouteLifetime != nil {
+		cfg.RouteLifetime = *ic.RouteLifetime
+	}
+	if ic.DefaultRouteLifetime != nil {
+		cfg.DefaultRouteLifetime = *ic.DefaultRouteLifetime
+	}
+	if ic.DefaultRoutePreference != nil {
+		cfg.DefaultRoutePreference = *ic.DefaultRoutePreference
+	}
+	if ic.ULAOnLinkOnly != nil {
+		cfg.ULAOnLinkOnly = *ic.ULAOnLinkOnly
+	}
+	if ic.HostRouteOnLink != nil {
+		cfg.HostRouteOnLink = *ic.HostRouteOnLink
+	}
+	if ic.SuppressAnycastOnLink != nil {
+		cfg.SuppressAnycastOnLink = *ic.SuppressAnycastOnLink
+	}
+	if ic.PrefixValidLifetime != nil {
+		cfg.PrefixValidLifetime = *ic.PrefixValidLifetime
+	}
+	if ic.PrefixPreferredLifetime != nil {
+		cfg.PrefixPreferredLifetime = *ic.PrefixPreferredLifetime
+	}
+	if ic.StaticPrefix != nil {
+		cfg.StaticPrefix = &ic.StaticPrefix.IPNet
+	}
+	if ic.IncludeSLLA != nil {
+		cfg.IncludeSLLA = ic.IncludeSLLA
+	}
+	if ic.RespondToRS != nil {
+		cfg.RespondToRS = ic.RespondToRS
+	}
+	if ic.Observe != nil {
+		cfg.Observe = *ic.Observe
+	}
+	if ic.WaitForHost != nil {
+		cfg.WaitForHost = *ic.WaitForHost
+	}
+	if ic.DialTimeout != nil {
+		cfg.DialTimeout = *ic.DialTimeout
+	}
+	if ic.ForceMulticastResponse != nil {
+		cfg.ForceMulticastResponse = *ic.ForceMulticastResponse
+	}
+	if ic.MaxRSPerSource != nil {
+		cfg.MaxRSPerSource = *ic.MaxRSPerSource
+	}
+	if ic.AcceptICMP != nil {
+		cfg.AcceptICMP = ic.AcceptICMP
+	}
+	if ic.SourceLinkLocal != nil {
+		cfg.SourceLinkLocal = ic.SourceLinkLocal
+	}
+	if ic.UnicastPeriodicTo != nil {
+		cfg.UnicastPeriodicTo = ic.UnicastPeriodicTo
+	}
+	if ic.RespondToNS != nil {
+		cfg.RespondToNS = *ic.RespondToNS
+	}
+	if ic.Mode != nil {
+		cfg.Mode = *ic.Mode
+	}
+	return cfg
+}
+
+// forInterface returns the config with the override for ifName merged in
+func (cfg TapConfig) forInterface(ifName string) TapConfig {
+	ic, ok := cfg.Interfaces[ifName]
+	if !ok {
+		return cfg
+	}
+	return ic.merge(cfg)
+}
+
+// Validate checks the config as well as every interface override the same way NewTap would
+func (cfg TapConfig) Validate() error {
+	if err := (&Tap{Ifi: &net.Interface{}}).apply(cfg); err != nil {
+		return err
+	}
+	for name := range cfg.Interfaces {
+		if err := (&Tap{Ifi: &net.Interface{Name: name}}).apply(cfg.forInterface(name)); err != nil {
+			return fmt.Errorf("interface %s: %w", name, err)
+		}
+	}
+	return nil
+}
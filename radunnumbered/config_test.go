@@ -0,0 +1,44 @@
+package radunnumbered
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveConfigSourceLinkLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rad.yaml")
+	conf := []byte("defaults:\n  source_link_local: fe80::1\ninterfaces:\n  tap1_0:\n    source_link_local: fe80::2\n")
+	if err := ioutil.WriteFile(path, conf, 0644); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+
+	cfg, _, err := ResolveConfig(path, TapConfig{}, "tap.*_0")
+	if err != nil {
+		t.Fatalf("ResolveConfig failed: %v", err)
+	}
+	if !cfg.SourceLinkLocal.Equal(net.ParseIP("fe80::1")) {
+		t.Errorf("source link-local %s, want fe80::1", cfg.SourceLinkLocal)
+	}
+	if got := cfg.forInterface("tap1_0").SourceLinkLocal; !got.Equal(net.ParseIP("fe80::2")) {
+		t.Errorf("source link-local of tap1_0 %s, want fe80::2", got)
+	}
+
+	// the address still has to be link-local, that doesn't need the interface
+	if err := ioutil.WriteFile(path, []byte("defaults:\n  source_link_local: 2001:db8::1\n"), 0644); err != nil {
+		t.Fatalf("unable to write config: %v", err)
+	}
+	if _, _, err := ResolveConfig(path, TapConfig{}, "tap.*_0"); err == nil {
+		t.Error("ResolveConfig accepted a global source address")
+	}
+}
+
+func TestNewTapSourceLinkLocalNotConfigured(t *testing.T) {
+	// lo has no link-local address at all
+	_, err := NewTap(loopback(t).Index, WithConfig(TapConfig{SourceLinkLocal: net.ParseIP("fe80::1")}),
+		WithRouteProvider(&fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}))
+	if err == nil {
+		t.Fatal("NewTap accepted a source address missing from the interface")
+	}
+}
@@ -0,0 +1,451 @@
+// Package radunnumbered advertises the /64s of the host routes pointing to unnumbered taps via SLAAC.
+// the Engine handles the taps, the rad-unnumbered binary is just feeding it from netlink and can be replaced the same way
+package radunnumbered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	ll "github.com/sirupsen/logrus"
+)
+
+// Engine is the main object collecting all running taps
+type Engine struct {
+	tap  map[int]*Tap
+	lock sync.RWMutex
+	// include selects the interfaces to handle by name, unless matching exclude (if set)
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	// listed are the ifindexes given explicitly, handled even if not matching include. guarded by lock
+	listed map[int]bool
+	cfg    TapConfig
+	// maxTaps limits the number of taps handled at once, each one costs a go routine and a raw socket
+	maxTaps int
+	// strictPrefixes refuses taps sharing a /64 with an other tap instead of just warning
+	strictPrefixes bool
+	// events receives the lifecycle events of the taps if set
+	events chan<- Event
+	// routes looks up the routes of the taps, overriding the RouteProvider of cfg. nil keeps the one of cfg
+	routes RouteProvider
+	// lastErrors holds why recently failed taps closed by ifindex, kept for lastErrorRetention. guarded by lock
+	lastErrors map[int]TapError
+	// generation is handed out to every added tap, the kernel reuses ifindexes of deleted interfaces. guarded by lock
+	generation uint64
+	// wg tracks the running tap go routines so shutdown can wait for them
+	wg sync.WaitGroup
+}
+
+// lastErrorRetention is how long the error of a failed tap can be looked up after it closed
+const lastErrorRetention = 5 * time.Minute
+
+// TapError is why a tap closed other than being closed on purpose
+type TapError struct {
+	Name   string
+	Err    error
+	Closed time.Time
+}
+
+// DefaultMaxTaps is the number of taps an engine handles at most, unless set with WithMaxTaps
+const DefaultMaxTaps = 4096
+
+// EngineOption customizes an engine created by NewEngine
+type EngineOption func(*Engine) error
+
+// WithMaxTaps limits the number of taps handled at once
+func WithMaxTaps(n int) EngineOption {
+	return func(e *Engine) error {
+		e.maxTaps = n
+		return nil
+	}
+}
+
+// WithStrictPrefixes refuses taps deriving the same prefix as an existing one, instead of only warning
+func WithStrictPrefixes(strict bool) EngineOption {
+	return func(e *Engine) error {
+		e.strictPrefixes = strict
+		return nil
+	}
+}
+
+// WithExclude skips interfaces matching regex, even if they match the include regex. empty excludes nothing
+func WithExclude(regex string) EngineOption {
+	return func(e *Engine) error {
+		if regex == "" {
+			return nil
+		}
+		r, err := regexp.Compile(regex)
+		if err != nil {
+			return fmt.Errorf("unable to parse interface exclude regex %s: %w", regex, err)
+		}
+		e.exclude = r
+		return nil
+	}
+}
+
+// WithRoutes makes the taps look up their routes via p instead of netlink, i.e. for an other netns or in tests
+func WithRoutes(p RouteProvider) EngineOption {
+	return func(e *Engine) error {
+		e.routes = p
+		return nil
+	}
+}
+
+// WithEvents makes the engine send lifecycle events of its taps to ch.
+// sending never blocks, events are dropped if ch is full so buffer it according to the consumer
+func WithEvents(ch chan<- Event) EngineOption {
+	return func(e *Engine) error {
+		e.events = ch
+		return nil
+	}
+}
+
+// NewEngine just setups up a empty new engine handling the interfaces matching regex, cfg is applied to every tap added
+func NewEngine(regex string, cfg TapConfig, opts ...EngineOption) (*Engine, error) {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse interface regex %s: %w", regex, err)
+	}
+
+	e := &Engine{
+		tap:        make(map[int]*Tap),
+		lastErrors: make(map[int]TapError),
+		listed:     make(map[int]bool),
+		lock:       sync.RWMutex{},
+		include:    r,
+		cfg:        cfg,
+		maxTaps:    DefaultMaxTaps,
+	}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Qualifies checks if interface qulalifies, aka matches the regex for taps to be handled and not the exclude one
+func (e *Engine) Qualifies(ifName string) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.qualifies(ifName)
+}
+
+// qualifies is Qualifies with the lock held already
+func (e *Engine) qualifies(ifName string) bool {
+	if e.exclude != nil && e.exclude.MatchString(ifName) {
+		return false
+	}
+	return e.include.MatchString(ifName)
+}
+
+// Listed checks if the interface was given explicitly via SetListed - thread safe
+func (e *Engine) Listed(ifIdx int) bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.listed[ifIdx]
+}
+
+// accepts checks if the interface is to be handled, either qualifying or listed and not excluded. needs the lock
+func (e *Engine) accepts(ifIdx int, ifName string) bool {
+	if e.listed[ifIdx] {
+		return e.exclude == nil || !e.exclude.MatchString(ifName)
+	}
+	return e.qualifies(ifName)
+}
+
+// SetListed replaces the interfaces handled regardless of the regex, i.e. given on the command line or in a file.
+// they still have to be added, taps no longer listed nor qualifying are closed
+func (e *Engine) SetListed(ifIdxs []int) {
+	listed := make(map[int]bool, len(ifIdxs))
+	for _, idx := range ifIdxs {
+		listed[idx] = true
+	}
+
+	var drop []int
+	e.lock.Lock()
+	e.listed = listed
+	for idx, t := range e.tap {
+		if !e.accepts(idx, t.Ifi.Name) {
+			drop = append(drop, idx)
+		}
+	}
+	e.lock.Unlock()
+
+	for _, idx := range drop {
+		e.Close(idx)
+	}
+}
+
+// Reload swaps regex and config while running. running taps pick up the new config with their next RA,
+// taps no longer matching the regex are closed unless listed
+func (e *Engine) Reload(regex string, cfg TapConfig) error {
+	r, err := regexp.Compile(regex)
+	if err != nil {
+		return fmt.Errorf("unable to parse interface regex %s: %w", regex, err)
+	}
+
+	var drop []int
+	var keep []*Tap
+	e.lock.Lock()
+	e.include = r
+	e.cfg = cfg
+	for idx, t := range e.tap {
+		if !e.accepts(idx, t.Ifi.Name) {
+			drop = append(drop, idx)
+			continue
+		}
+		keep = append(keep, t)
+	}
+	e.lock.Unlock()
+
+	for _, idx := range drop {
+		e.Close(idx)
+	}
+	// reconfiguring may look up routes, the engine must not be blocked meanwhile
+	for _, t := range keep {
+		if err := t.Reconfigure(cfg); err != nil {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Errorf("failed reconfiguring %s: %v", t.Ifi.Name, err)
+		}
+	}
+	return nil
+}
+
+// ErrTapExists is returned by Add if the interface is already handled
+var ErrTapExists = errors.New("tap already exists")
+
+// ErrPrefixConflict is returned by Add if the tap shares a prefix with an other tap and strictPrefixes is set
+var ErrPrefixConflict = errors.New("prefix conflict")
+
+// ErrNotQualified is returned by Add if the interface name doesn't qualify and it isn't listed either
+var ErrNotQualified = errors.New("interface does not qualify")
+
+// ErrTooManyTaps is returned by Add if the engine handles maxTaps already
+var ErrTooManyTaps = errors.New("too many taps")
+
+// Add adds a new Interface to be handled by the engine, errors are logged and returned
+func (e *Engine) Add(ifIdx int) error {
+	// the policy is enforced here, whoever feeds us the index
+	ifi, err := net.InterfaceByIndex(ifIdx)
+	if err != nil {
+		ll.WithFields(ll.Fields{"InterfaceID": ifIdx}).Errorf("failed adding ifIndex %d: %s", ifIdx, err)
+		return fmt.Errorf("failed adding ifIndex %d: %w", ifIdx, err)
+	}
+	e.lock.RLock()
+	accepted := e.accepts(ifIdx, ifi.Name)
+	cfg := e.cfg
+	full := len(e.tap) >= e.maxTaps
+	e.lock.RUnlock()
+	if !accepted {
+		ll.WithFields(ll.Fields{"Interface": ifi.Name}).Debugf("%s did not qualify, skipping...", ifi.Name)
+		return ErrNotQualified
+	}
+	// checking upfront already, no need to look at the routes of a tap which won't be added anyway
+	if full {
+		ll.WithFields(ll.Fields{"InterfaceID": ifIdx}).Errorf("not adding ifIndex %d, limit of %d taps reached", ifIdx, e.maxTaps)
+		return ErrTooManyTaps
+	}
+
+	opts := []TapOption{WithConfig(cfg)}
+	// without WithRoutes the RouteProvider of the config (if any) is used
+	if e.routes != nil {
+		opts = append(opts, WithRouteProvider(e.routes))
+	}
+	t, err := NewTap(ifIdx, opts...)
+	if err != nil {
+		ll.WithFields(ll.Fields{"InterfaceID": ifIdx}).Errorf("failed adding ifIndex %d: %s", ifIdx, err)
+		return fmt.Errorf("failed adding ifIndex %d: %w", ifIdx, err)
+	}
+
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("adding %s with prefixes %s", t.Ifi.Name, t.Prefixes())
+
+	// need to lock/handle concurrency due to the cleanup inside the go routine
+	e.lock.Lock()
+	// the tap may have been added concurrently (netlink feed vs control api) in the meantime
+	if _, exists := e.tap[ifIdx]; exists {
+		e.lock.Unlock()
+		t.Close()
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("%s already handled, skipping", t.Ifi.Name)
+		return ErrTapExists
+	}
+	if len(e.tap) >= e.maxTaps {
+		e.lock.Unlock()
+		t.Close()
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Errorf("not adding %s, limit of %d taps reached", t.Ifi.Name, e.maxTaps)
+		return ErrTooManyTaps
+	}
+	if conflicts := e.prefixConflicts(t); len(conflicts) > 0 && e.strictPrefixes {
+		e.lock.Unlock()
+		t.Close()
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Errorf("not adding %s, prefixes conflict with %s", t.Ifi.Name, conflicts)
+		return fmt.Errorf("%w: %s shares prefixes with %s", ErrPrefixConflict, t.Ifi.Name, strings.Join(conflicts, ", "))
+	}
+	e.store(ifIdx, t)
+	metricTapsActive.Set(float64(len(e.tap)))
+	e.lock.Unlock()
+	e.emit(TapAdded, t)
+	t.onListening = func() { e.emit(TapListening, t) }
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		if err := t.Listen(); err != nil {
+			// Context cancel means a signal was sent, so no need to log an error.
+			if err == context.Canceled {
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s closed", t.Ifi.Name)
+			} else if errors.Is(err, errDialTimeout) {
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Errorf("%s never came up, dropping it: %s", t.Ifi.Name, err)
+			} else {
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Errorf("%s failed with %s", t.Ifi.Name, err)
+			}
+
+			// cleanup after closing up
+			e.lock.Lock()
+			// the index may already be handled by a new tap if the interface came back quickly
+			if e.owns(ifIdx, t.generation) {
+				delete(e.tap, ifIdx)
+			}
+			if err != context.Canceled {
+				e.pruneLastErrors()
+				e.lastErrors[ifIdx] = TapError{Name: t.Ifi.Name, Err: err, Closed: time.Now()}
+			}
+			metricTapsActive.Set(float64(len(e.tap)))
+			e.lock.Unlock()
+			forgetTapMetrics(t.Ifi.Name)
+			e.emit(TapClosed, t)
+		}
+	}()
+	return nil
+}
+
+// store hands t the next generation and makes it the tap at ifIdx. needs the lock to be held
+func (e *Engine) store(ifIdx int, t *Tap) {
+	e.generation++
+	t.generation = e.generation
+	e.tap[ifIdx] = t
+}
+
+// owns checks if the tap at ifIdx is still the one added as generation. needs the lock to be held
+func (e *Engine) owns(ifIdx int, generation uint64) bool {
+	t, exists := e.tap[ifIdx]
+	return exists && t.generation == generation
+}
+
+// prefixConflicts warns about every other tap advertising one of the /64s of t, returning their names.
+// hosts on both segments would end up with colliding addresses. needs the lock to be held
+func (e *Engine) prefixConflicts(t *Tap) []string {
+	var conflicts []string
+	for _, o := range e.tap {
+		for _, p := range t.Prefixes() {
+			if !containsIP(o.Prefixes(), p) {
+				continue
+			}
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Conflict": o.Ifi.Name}).
+				Warnf("%s and %s both advertise prefix %s/64", t.Ifi.Name, o.Ifi.Name, p)
+			conflicts = append(conflicts, o.Ifi.Name)
+			break
+		}
+	}
+	return conflicts
+}
+
+// RefreshRoutes updates the advertised prefixes of a tap after a route change - thread safe
+func (e *Engine) RefreshRoutes(ifIdx int) {
+	e.lock.RLock()
+	tap, exists := e.tap[ifIdx]
+	e.lock.RUnlock()
+	if !exists {
+		return
+	}
+	if err := tap.RefreshRoutes(); err != nil {
+		ll.WithFields(ll.Fields{"Interface": tap.Ifi.Name}).Warnf("failed refreshing routes: %v", err)
+	}
+}
+
+// SetLinkUp pauses or resumes a tap according to its link state - thread safe
+func (e *Engine) SetLinkUp(ifIdx int, up bool) {
+	e.lock.RLock()
+	tap, exists := e.tap[ifIdx]
+	e.lock.RUnlock()
+	if exists {
+		tap.SetLinkUp(up)
+	}
+}
+
+// Get returns a lookedup Tap interface thread safe, nil if not handled
+func (e *Engine) Get(ifIdx int) *Tap {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.tap[ifIdx]
+}
+
+// LastError returns why the tap of ifIdx failed, if it did within the last few minutes
+func (e *Engine) LastError(ifIdx int) (TapError, bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.pruneLastErrors()
+	te, ok := e.lastErrors[ifIdx]
+	return te, ok
+}
+
+// pruneLastErrors forgets the errors older than lastErrorRetention, the caller has to hold the lock
+func (e *Engine) pruneLastErrors() {
+	for idx, te := range e.lastErrors {
+		if time.Since(te.Closed) > lastErrorRetention {
+			delete(e.lastErrors, idx)
+		}
+	}
+}
+
+// Exists verifies (thread safe) if tap  is already handled or not
+func (e *Engine) Exists(ifIdx int) bool {
+	e.lock.RLock()
+	_, exists := e.tap[ifIdx]
+	e.lock.RUnlock()
+	return exists
+}
+
+// Shutdown closes all taps and waits up to timeout for them to finish (sending their final RA)
+func (e *Engine) Shutdown(timeout time.Duration) error {
+	e.lock.RLock()
+	var idxs []int
+	for idx := range e.tap {
+		idxs = append(idxs, idx)
+	}
+	e.lock.RUnlock()
+
+	for _, idx := range idxs {
+		e.Close(idx)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v waiting for taps to close", timeout)
+	}
+}
+
+// Close stops handling a Tap interfaces and drops it from the map - thread safe
+func (e *Engine) Close(ifIdx int) {
+	e.lock.RLock()
+	tap, exists := e.tap[ifIdx]
+	e.lock.RUnlock()
+	if !exists {
+		return
+	}
+	ifName := tap.Ifi.Name
+	ll.WithFields(ll.Fields{"Interface": ifName}).Infof("removing %s", ifName)
+	tap.Close()
+}
@@ -0,0 +1,287 @@
+package radunnumbered
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/ndp"
+)
+
+// newTestEngine handles the loopback interface only, with the routes of its taps looked up from p
+func newTestEngine(t *testing.T, p RouteProvider, events chan<- Event) *Engine {
+	t.Helper()
+	// lo doesn't do multicast, so the taps must not join the all-routers group
+	respond := false
+	e, err := NewEngine("^"+loopback(t).Name+"$", TapConfig{RespondToRS: &respond}, WithRoutes(p), WithEvents(events))
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	t.Cleanup(func() { e.Shutdown(time.Second) })
+	return e
+}
+
+// waitEvent returns the next event of type et, failing the test if none arrives within timeout
+func waitEvent(t *testing.T, events <-chan Event, et EventType, timeout time.Duration) Event {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == et {
+				return ev
+			}
+		case <-deadline:
+			t.Fatalf("no %s event within %v", et, timeout)
+			return Event{}
+		}
+	}
+}
+
+func TestCloseCancelsTap(t *testing.T) {
+	c := newFakeConn()
+	stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+		return c, net.ParseIP("fe80::1"), nil
+	})
+	events := make(chan Event, 16)
+	e := newTestEngine(t, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}, events)
+	idx := loopback(t).Index
+
+	if err := e.Add(idx); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	tap := e.Get(idx)
+	if tap == nil {
+		t.Fatal("tap not handled after Add")
+	}
+	// the loopback interface has no carrier as far as netlink is concerned
+	tap.SetLinkUp(true)
+	waitEvent(t, events, TapListening, time.Second)
+	waitRA(t, c, net.IPv6linklocalallnodes, time.Second)
+
+	e.Close(idx)
+	waitEvent(t, events, TapClosed, finalRATimeout+time.Second)
+	if e.Exists(idx) {
+		t.Error("tap still handled after Close")
+	}
+	if !c.isClosed() {
+		t.Error("connection of the tap not closed")
+	}
+	if err := tap.ctx.Err(); err == nil {
+		t.Error("context of the tap not canceled")
+	}
+	// the go routine started by Add has to be gone
+	if err := e.Shutdown(time.Second); err != nil {
+		t.Errorf("tap go routine still running: %v", err)
+	}
+}
+
+func TestAddFailingTap(t *testing.T) {
+	tests := []struct {
+		name string
+		idx  int
+	}{
+		// ifindexes are far from exhausted in any test environment
+		{"unknown ifindex", 1 << 30},
+		{"tap without routes", loopback(t).Index},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dials int32
+			stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+				atomic.AddInt32(&dials, 1)
+				return newFakeConn(), net.ParseIP("fe80::1"), nil
+			})
+			events := make(chan Event, 16)
+			e := newTestEngine(t, &fakeRoutes{}, events)
+
+			if err := e.Add(tt.idx); err == nil {
+				t.Fatal("Add succeeded")
+			}
+			e.lock.RLock()
+			taps := len(e.tap)
+			e.lock.RUnlock()
+			if taps != 0 {
+				t.Errorf("%d taps handled after a failed Add", taps)
+			}
+			if err := e.Shutdown(100 * time.Millisecond); err != nil {
+				t.Errorf("go routine running for a failed Add: %v", err)
+			}
+			if n := atomic.LoadInt32(&dials); n != 0 {
+				t.Errorf("dialed %d times for a failed Add", n)
+			}
+			select {
+			case ev := <-events:
+				t.Errorf("unexpected %s event for a failed Add", ev.Type)
+			default:
+			}
+		})
+	}
+}
+
+func TestCleanupKeepsReusedIndex(t *testing.T) {
+	stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+		return newFakeConn(), net.ParseIP("fe80::1"), nil
+	})
+	events := make(chan Event, 16)
+	routes := &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}
+	e := newTestEngine(t, routes, events)
+	idx := loopback(t).Index
+
+	if err := e.Add(idx); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	old := e.Get(idx)
+	old.SetLinkUp(true)
+	waitEvent(t, events, TapListening, time.Second)
+
+	// the old tap closes, but its cleanup only gets the lock once the index got handed to the recreated interface
+	e.lock.Lock()
+	old.Close()
+	recreated, err := NewTap(idx, WithConfig(e.cfg), WithRouteProvider(routes))
+	if err != nil {
+		e.lock.Unlock()
+		t.Fatalf("NewTap failed: %v", err)
+	}
+	delete(e.tap, idx)
+	e.store(idx, recreated)
+	e.lock.Unlock()
+
+	waitEvent(t, events, TapClosed, finalRATimeout+time.Second)
+	if got := e.Get(idx); got != recreated {
+		t.Errorf("cleanup of the old tap dropped the recreated one, got %p want %p", got, recreated)
+	}
+	if recreated.generation == old.generation {
+		t.Errorf("recreated tap got the old generation %d", old.generation)
+	}
+}
+
+func TestListedBypassesRegex(t *testing.T) {
+	stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+		return newFakeConn(), net.ParseIP("fe80::1"), nil
+	})
+	respond := false
+	cfg := TapConfig{RespondToRS: &respond}
+	events := make(chan Event, 16)
+	e, err := NewEngine("^tap.*_0$", cfg, WithRoutes(&fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}), WithEvents(events))
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	t.Cleanup(func() { e.Shutdown(time.Second) })
+	idx := loopback(t).Index
+
+	if err := e.Add(idx); !errors.Is(err, ErrNotQualified) {
+		t.Fatalf("Add of an interface not matching the regex returned %v, want %v", err, ErrNotQualified)
+	}
+	e.SetListed([]int{idx})
+	if err := e.Add(idx); err != nil {
+		t.Fatalf("Add of a listed interface failed: %v", err)
+	}
+	// reloading still doesn't match it, being listed keeps it
+	if err := e.Reload("^tap.*_0$", cfg); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if !e.Exists(idx) {
+		t.Fatal("listed tap closed by Reload")
+	}
+
+	e.SetListed(nil)
+	waitEvent(t, events, TapClosed, time.Second)
+	if e.Exists(idx) {
+		t.Error("tap no longer listed still handled")
+	}
+}
+
+func TestListedStillExcluded(t *testing.T) {
+	idx := loopback(t).Index
+	e, err := NewEngine("^tap.*_0$", TapConfig{}, WithRoutes(&fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}),
+		WithExclude("^"+loopback(t).Name+"$"))
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	e.SetListed([]int{idx})
+	if err := e.Add(idx); !errors.Is(err, ErrNotQualified) {
+		t.Errorf("Add of a listed but excluded interface returned %v, want %v", err, ErrNotQualified)
+	}
+}
+
+func TestEngineUsesConfigRouteProvider(t *testing.T) {
+	p := &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}
+	respond := false
+	e, err := NewEngine("^"+loopback(t).Name+"$", TapConfig{RespondToRS: &respond, RouteProvider: p})
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	t.Cleanup(func() { e.Shutdown(time.Second) })
+
+	if err := e.Add(loopback(t).Index); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if atomic.LoadInt32(&p.calls) == 0 {
+		t.Error("routes not looked up via the RouteProvider of the config")
+	}
+}
+
+// blockingRoutes is a RouteProvider hanging once blocked, like a stuck netlink query, until released
+type blockingRoutes struct {
+	fakeRoutes
+	blocked int32
+	entered chan struct{}
+	release chan struct{}
+}
+
+// Routes implements RouteProvider
+func (b *blockingRoutes) Routes(ifIdx int) ([]*net.IPNet, []*net.IPNet, error) {
+	if atomic.LoadInt32(&b.blocked) != 0 {
+		b.entered <- struct{}{}
+		<-b.release
+	}
+	return b.fakeRoutes.Routes(ifIdx)
+}
+
+func TestReloadDoesntBlockOnRoutes(t *testing.T) {
+	p := &blockingRoutes{
+		fakeRoutes: fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")},
+		entered:    make(chan struct{}, 1),
+		release:    make(chan struct{}),
+	}
+	e := newTestEngine(t, p, nil)
+	idx := loopback(t).Index
+	if err := e.Add(idx); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	tap := e.Get(idx)
+
+	// excluding a subnet makes the taps look up their routes again
+	atomic.StoreInt32(&p.blocked, 1)
+	cfg := e.cfg
+	_, exclude, _ := net.ParseCIDR("2001:db8:9::/64")
+	cfg.ExcludeSubnets = []net.IPNet{*exclude}
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- e.Reload("^"+loopback(t).Name+"$", cfg) }()
+	select {
+	case <-p.entered:
+	case <-time.After(time.Second):
+		t.Fatal("Reload didn't look up the routes")
+	}
+
+	checked := make(chan struct{})
+	go func() {
+		e.Exists(idx)
+		tap.Source()
+		close(checked)
+	}()
+	select {
+	case <-checked:
+	case <-time.After(time.Second):
+		t.Error("engine or tap locked while looking up routes on reload")
+	}
+
+	close(p.release)
+	if err := <-reloaded; err != nil {
+		t.Errorf("Reload failed: %v", err)
+	}
+	<-checked
+}
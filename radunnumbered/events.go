@@ -0,0 +1,52 @@
+package radunnumbered
+
+import (
+	ll "github.com/sirupsen/logrus"
+)
+
+// EventType tells what happened to a tap
+type EventType int
+
+const (
+	// TapAdded is emitted once a tap is handled by the engine
+	TapAdded EventType = iota
+	// TapListening is emitted whenever the tap dialed its connection and starts advertising
+	TapListening
+	// TapClosed is emitted once a tap stopped and got dropped from the engine
+	TapClosed
+)
+
+func (et EventType) String() string {
+	switch et {
+	case TapAdded:
+		return "added"
+	case TapListening:
+		return "listening"
+	case TapClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Event is a lifecycle notification of a tap
+type Event struct {
+	Type  EventType
+	Index int
+	Name  string
+}
+
+// emit sends an event to the consumer if there is one, without blocking
+func (e *Engine) emit(et EventType, t *Tap) {
+	e.lock.RLock()
+	ch := e.events
+	e.lock.RUnlock()
+	if ch == nil {
+		return
+	}
+
+	select {
+	case ch <- Event{Type: et, Index: t.Ifi.Index, Name: t.Ifi.Name}:
+	default:
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("dropping %s event of %s, consumer too slow", et, t.Ifi.Name)
+	}
+}
@@ -0,0 +1,152 @@
+//go:build integration
+// +build integration
+
+package radunnumbered
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/ipv6"
+)
+
+// run with: go test -tags integration -run Integration ./radunnumbered/ (as root)
+
+// disableDAD makes the link-local address of ifName usable right away instead of after duplicate address detection
+func disableDAD(t *testing.T, ifName string) {
+	t.Helper()
+	if err := ioutil.WriteFile("/proc/sys/net/ipv6/conf/"+ifName+"/accept_dad", []byte("0"), 0644); err != nil {
+		t.Fatalf("unable to disable DAD on %s: %v", ifName, err)
+	}
+}
+
+// vethRuns numbers the veth pairs, go caches interface names for a minute so -count must not reuse them
+var vethRuns int
+
+// waitLinkLocal waits for ifi to get its link-local address
+func waitLinkLocal(t *testing.T, ifi *net.Interface) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(linkLocalAddrs(ifi)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s never got a link-local address", ifi.Name)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestIntegrationRSOverVeth(t *testing.T) {
+	vethRuns++
+	tapName, hostName := fmt.Sprintf("radint%da", vethRuns), fmt.Sprintf("radint%db", vethRuns)
+	tapLink := vethPair(t, tapName, hostName)
+	host, err := netlink.LinkByName(hostName)
+	if err != nil {
+		t.Fatalf("unable to look up %s: %v", hostName, err)
+	}
+	for _, l := range []netlink.Link{tapLink, host} {
+		disableDAD(t, l.Attrs().Name)
+		if err := netlink.LinkSetUp(l); err != nil {
+			t.Fatalf("unable to set %s up: %v", l.Attrs().Name, err)
+		}
+	}
+	// the host route the engine derives the prefix from, like the hypervisor sets up for a VM
+	_, dst, _ := net.ParseCIDR("2001:db8:1::5/128")
+	if err := netlink.RouteAdd(&netlink.Route{LinkIndex: tapLink.Attrs().Index, Dst: dst}); err != nil {
+		t.Fatalf("unable to add host route: %v", err)
+	}
+
+	// waiting for the carrier, the netlink feed of the binary only adds taps once they are up
+	deadline := time.Now().Add(5 * time.Second)
+	for !linkUpByIndex(tapLink.Attrs().Index) {
+		if time.Now().After(deadline) {
+			t.Fatal("veth never came up")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	events := make(chan Event, 16)
+	e, err := NewEngine("^"+tapName+"$", TapConfig{}, WithEvents(events))
+	if err != nil {
+		t.Fatalf("NewEngine failed: %v", err)
+	}
+	t.Cleanup(func() { e.Shutdown(5 * time.Second) })
+
+	hostIfi, err := net.InterfaceByName(hostName)
+	if err != nil {
+		t.Fatalf("unable to get %s: %v", hostName, err)
+	}
+	waitLinkLocal(t, hostIfi)
+	c, hostAddr, err := ndp.Listen(hostIfi, ndp.LinkLocal)
+	if err != nil {
+		t.Fatalf("unable to listen on %s: %v", hostName, err)
+	}
+	defer c.Close()
+	f := &ipv6.ICMPFilter{}
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterAdvertisement)
+	if err := c.SetICMPFilter(f); err != nil {
+		t.Fatalf("unable to set ICMP filter: %v", err)
+	}
+	if err := c.SetControlMessage(ipv6.FlagDst|ipv6.FlagHopLimit, true); err != nil {
+		t.Fatalf("unable to enable control messages: %v", err)
+	}
+
+	if err := e.Add(tapLink.Attrs().Index); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	waitEvent(t, events, TapListening, 10*time.Second)
+	source := e.Get(tapLink.Attrs().Index).Source()
+
+	// the initial RA goes out right away, the solicited one is the next addressed to the host
+	readRA(t, c, source, net.IPv6linklocalallnodes, 5*time.Second)
+	rs := &ndp.RouterSolicitation{Options: []ndp.Option{
+		&ndp.LinkLayerAddress{Direction: ndp.Source, Addr: hostIfi.HardwareAddr},
+	}}
+	if err := c.WriteTo(rs, nil, net.IPv6linklocalallrouters); err != nil {
+		t.Fatalf("unable to send RS: %v", err)
+	}
+	ra := readRA(t, c, source, hostAddr, 2*minDelayBetweenRAs)
+
+	if ra.RouterLifetime == 0 {
+		t.Error("router lifetime 0, want a default router")
+	}
+	var pio *ndp.PrefixInformation
+	for _, o := range ra.Options {
+		if p, ok := o.(*ndp.PrefixInformation); ok {
+			pio = p
+		}
+	}
+	if pio == nil || !pio.Prefix.Equal(net.ParseIP("2001:db8:1::")) || pio.PrefixLength != 64 {
+		t.Errorf("prefix information %+v, want 2001:db8:1::/64", pio)
+	} else if !pio.AutonomousAddressConfiguration || pio.ValidLifetime == 0 {
+		t.Errorf("prefix information %+v not usable for SLAAC", pio)
+	}
+}
+
+// readRA returns the next RA from source to dst received on c, failing the test if none arrives within timeout.
+// RAs have to be sent with a hop limit of 255 (RFC 4861 section 6.1.2)
+func readRA(t *testing.T, c *ndp.Conn, source, dst net.IP, timeout time.Duration) *ndp.RouterAdvertisement {
+	t.Helper()
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatalf("unable to set read deadline: %v", err)
+	}
+	for {
+		msg, cm, from, err := c.ReadFrom()
+		if err != nil {
+			t.Fatalf("no RA from %s to %s within %v: %v", source, dst, timeout, err)
+		}
+		ra, ok := msg.(*ndp.RouterAdvertisement)
+		if !ok || !from.Equal(source) || cm == nil || !cm.Dst.Equal(dst) {
+			continue
+		}
+		if cm.HopLimit != 255 {
+			t.Errorf("RA sent with hop limit %d, want 255", cm.HopLimit)
+		}
+		return ra
+	}
+}
@@ -0,0 +1,56 @@
+package radunnumbered
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	metricRASent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "radunnumbered",
+		Name:      "ra_sent_total",
+		Help:      "Router advertisements sent.",
+	}, []string{"interface"})
+	metricRSReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "radunnumbered",
+		Name:      "rs_received_total",
+		Help:      "Router solicitations received.",
+	}, []string{"interface"})
+	metricRSCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "radunnumbered",
+		Name:      "rs_coalesced_total",
+		Help:      "Router solicitations not queued on their own, an already pending RA answers them.",
+	}, []string{"interface"})
+	metricRSSuppressed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "radunnumbered",
+		Name:      "rs_suppressed_total",
+		Help:      "Router solicitations not answered, their source exceeded the per source limit.",
+	}, []string{"interface"})
+	metricRASendDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "radunnumbered",
+		Name:      "ra_send_duration_seconds",
+		Help:      "Time it took to marshal and write a router advertisement.",
+		// from 10us, sends blocking on the raw socket show up in the upper buckets
+		Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, []string{"interface"})
+	metricTapsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "radunnumbered",
+		Name:      "taps_active",
+		Help:      "Taps currently handled.",
+	})
+	metricTapsDialing = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "radunnumbered",
+		Name:      "taps_dialing",
+		Help:      "Taps currently failing to dial, retrying until their dial timeout.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(metricRASent, metricRSReceived, metricRSCoalesced, metricRSSuppressed, metricRASendDuration, metricTapsActive, metricTapsDialing)
+}
+
+// forgetTapMetrics drops the per interface series of a tap that went away
+func forgetTapMetrics(ifName string) {
+	metricRASent.DeleteLabelValues(ifName)
+	metricRSReceived.DeleteLabelValues(ifName)
+	metricRSCoalesced.DeleteLabelValues(ifName)
+	metricRSSuppressed.DeleteLabelValues(ifName)
+	metricRASendDuration.DeleteLabelValues(ifName)
+}
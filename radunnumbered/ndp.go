@@ -0,0 +1,30 @@
+package radunnumbered
+
+import (
+	"net"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"golang.org/x/net/ipv6"
+)
+
+// ndpConn is the subset of *ndp.Conn used by a tap, so the connection can be replaced in tests
+type ndpConn interface {
+	ReadFrom() (ndp.Message, *ipv6.ControlMessage, net.IP, error)
+	WriteTo(m ndp.Message, cm *ipv6.ControlMessage, dst net.IP) error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetICMPFilter(f *ipv6.ICMPFilter) error
+	JoinGroup(group net.IP) error
+	Close() error
+}
+
+// ndpListen dials the ndp connection of a tap, swappable to inject a fake ndpConn
+var ndpListen = func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+	c, ip, err := ndp.Listen(ifi, addr)
+	if err != nil {
+		// not handing out a typed nil wrapped in the interface
+		return nil, nil, err
+	}
+	return c, ip, nil
+}
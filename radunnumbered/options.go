@@ -0,0 +1,192 @@
+package radunnumbered
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mdlayher/ndp"
+)
+
+// ndp option types not implemented by the ndp package
+const (
+	optPrefixInformation = 3
+	optAdvInterval       = 7
+	optPREF64            = 38
+)
+
+// routerAddressPIO builds a /64 prefix information option with the R bit set, carrying the full router address
+// in the prefix field (RFC 6275 section 7.2). the ndp package only allows a masked prefix there, so done by hand
+func routerAddressPIO(f PrefixFlags, valid, preferred time.Duration) *ndp.RawOption {
+	value := make([]byte, 30)
+	value[0] = 64
+	if f.OnLink {
+		value[1] |= 1 << 7
+	}
+	if f.Autonomous {
+		value[1] |= 1 << 6
+	}
+	value[1] |= 1 << 5
+	binary.BigEndian.PutUint32(value[2:6], uint32(valid.Seconds()))
+	binary.BigEndian.PutUint32(value[6:10], uint32(preferred.Seconds()))
+	// 4 bytes reserved
+	copy(value[14:30], f.RouterAddress.To16())
+
+	return &ndp.RawOption{
+		Type:   optPrefixInformation,
+		Length: 4,
+		Value:  value,
+	}
+}
+
+// maxAdvInterval is the longest interval in milliseconds fitting the (32 bit) advertisement interval option
+const maxAdvInterval = math.MaxUint32 * time.Millisecond
+
+// checkAdvInterval verifies the interval fits into the advertisement interval option
+func checkAdvInterval(d time.Duration) error {
+	if d > maxAdvInterval {
+		return fmt.Errorf("RA interval %v too long for the advertisement interval option, must be at most %v", d, maxAdvInterval)
+	}
+	return nil
+}
+
+// advIntervalOption builds the advertisement interval option (RFC 6275 section 7.3) with the max RA interval,
+// 2 reserved bytes followed by the interval in milliseconds
+func advIntervalOption(d time.Duration) *ndp.RawOption {
+	value := make([]byte, 6)
+	binary.BigEndian.PutUint32(value[2:], uint32(d/time.Millisecond))
+	return &ndp.RawOption{
+		Type:   optAdvInterval,
+		Length: 1,
+		Value:  value,
+	}
+}
+
+// maxCaptivePortalLen is the longest URI fitting the (8 bit) length of the captive portal option
+const maxCaptivePortalLen = 246
+
+// checkCaptivePortal verifies the captive portal URI is absolute and fits into the option
+func checkCaptivePortal(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid captive portal uri %q: %v", uri, err)
+	}
+	if !u.IsAbs() {
+		return fmt.Errorf("captive portal uri %q is not absolute", uri)
+	}
+	if len(uri) > maxCaptivePortalLen {
+		return fmt.Errorf("captive portal uri %q longer than %d bytes", uri, maxCaptivePortalLen)
+	}
+	return nil
+}
+
+// pref64PLC maps the NAT64 prefix lengths allowed by RFC 8781 to their prefix length code
+var pref64PLC = map[int]uint16{96: 0, 64: 1, 56: 2, 48: 3, 40: 4, 32: 5}
+
+// maxPREF64Lifetime is the highest lifetime the 13bit scaled lifetime (in units of 8s) can carry
+const maxPREF64Lifetime = 8191 * 8 * time.Second
+
+// checkPREF64 verifies the NAT64 prefix can be encoded in a PREF64 option
+func checkPREF64(prefix *net.IPNet) error {
+	l, bits := prefix.Mask.Size()
+	if bits != 128 || prefix.IP.To4() != nil {
+		return fmt.Errorf("nat64 prefix %s is not ipv6", prefix)
+	}
+	if _, ok := pref64PLC[l]; !ok {
+		return fmt.Errorf("nat64 prefix %s has invalid length /%d, must be one of 96, 64, 56, 48, 40 or 32", prefix, l)
+	}
+	return nil
+}
+
+// pref64Option builds the PREF64 option (RFC 8781 section 4), the prefix must have passed checkPREF64
+func pref64Option(prefix *net.IPNet, lifetime time.Duration) *ndp.RawOption {
+	if lifetime > maxPREF64Lifetime {
+		lifetime = maxPREF64Lifetime
+	}
+	// the lifetime is scaled to units of 8 seconds, rounding up so it doesn't expire before the next RA
+	scaled := uint16((lifetime + 8*time.Second - 1) / (8 * time.Second))
+	l, _ := prefix.Mask.Size()
+
+	value := make([]byte, 14)
+	binary.BigEndian.PutUint16(value[0:2], scaled<<3|pref64PLC[l])
+	copy(value[2:], prefix.IP.To16()[:12])
+
+	return &ndp.RawOption{
+		Type:   optPREF64,
+		Length: 2,
+		Value:  value,
+	}
+}
+
+// DNSServer is a recursive dns server advertised via RDNSS, a Lifetime of 0 uses the default dns lifetime
+type DNSServer struct {
+	Addr     net.IP
+	Lifetime time.Duration
+}
+
+func (d DNSServer) String() string {
+	if d.Lifetime == 0 {
+		return d.Addr.String()
+	}
+	return fmt.Sprintf("%s=%v", d.Addr, d.Lifetime)
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, the format is addr or addr=lifetime (2001:db8::53=10m)
+func (d *DNSServer) UnmarshalText(b []byte) error {
+	kv := strings.SplitN(string(b), "=", 2)
+	ip := net.ParseIP(kv[0])
+	if ip == nil {
+		return fmt.Errorf("invalid ip: %v", kv[0])
+	}
+	d.Addr = ip
+	d.Lifetime = 0
+	if len(kv) == 2 {
+		l, err := time.ParseDuration(kv[1])
+		if err != nil {
+			return fmt.Errorf("invalid dns server lifetime: %v", kv[1])
+		}
+		d.Lifetime = l
+	}
+	return nil
+}
+
+// checkDNSServer verifies the server is a usable ipv6 address with a sane lifetime
+func checkDNSServer(d DNSServer) error {
+	if d.Addr == nil || d.Addr.To4() != nil || d.Addr.To16() == nil {
+		return fmt.Errorf("dns server %v is not a ipv6 address", d.Addr)
+	}
+	if d.Addr.IsUnspecified() || d.Addr.IsMulticast() {
+		return fmt.Errorf("dns server %s is not a unicast address", d.Addr)
+	}
+	if d.Lifetime < 0 {
+		return fmt.Errorf("dns server %s lifetime %v must not be negative", d.Addr, d.Lifetime)
+	}
+	return nil
+}
+
+// rdnssOptions groups the servers by lifetime into one RDNSS option each, in the order the lifetimes appear.
+// servers without lifetime get defaultLifetime
+func rdnssOptions(servers []DNSServer, defaultLifetime time.Duration) []ndp.Option {
+	var lifetimes []time.Duration
+	groups := map[time.Duration][]net.IP{}
+	for _, s := range servers {
+		l := s.Lifetime
+		if l == 0 {
+			l = defaultLifetime
+		}
+		if _, ok := groups[l]; !ok {
+			lifetimes = append(lifetimes, l)
+		}
+		groups[l] = append(groups[l], s.Addr)
+	}
+
+	var options []ndp.Option
+	for _, l := range lifetimes {
+		options = append(options, &ndp.RecursiveDNSServer{Lifetime: l, Servers: groups[l]})
+	}
+	return options
+}
@@ -0,0 +1,703 @@
+package radunnumbered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	ll "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// finalRATimeout limits how long closing a tap may wait on the final RA
+	finalRATimeout = 2 * time.Second
+	// minDelayBetweenRAs is MIN_DELAY_BETWEEN_RAS from RFC 4861 section 10
+	minDelayBetweenRAs = 3 * time.Second
+)
+
+// sending the actual RA
+func (t *Tap) doRA(c ndpConn) error {
+	t.lock.RLock()
+	respond, observe, respondNS := t.RespondToRS, t.Observe, t.RespondToNS
+	listen := respond || respondNS || len(t.AcceptICMP) > 0 || (t.WaitForHost && !t.hostSeen)
+	t.lock.RUnlock()
+
+	eg, ctxx := errgroup.WithContext(t.ctx)
+	if observe {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s observing, logging solicits without sending RAs", t.Ifi.Name)
+		eg.Go(func() error { return t.receiveLoop(ctxx, c) })
+		// there is no send loop watching the link
+		eg.Go(func() error {
+			for {
+				select {
+				case <-ctxx.Done():
+					return ctxx.Err()
+				case <-t.linkChange:
+					if !t.LinkUp() {
+						return errLinkDown
+					}
+				}
+			}
+		})
+		return eg.Wait()
+	}
+	eg.Go(func() error { return t.sendLoop(ctxx, c) })
+	if !respond {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s passive, not answering solicits", t.Ifi.Name)
+	}
+	// the filter set up when dialing only lets through what is to be read
+	if listen {
+		eg.Go(func() error { return t.receiveLoop(ctxx, c) })
+	}
+
+	return eg.Wait()
+}
+
+// advertisement assembles the RouterAdvertisement including all options configured for this tap
+func (t *Tap) advertisement() *ndp.RouterAdvertisement {
+	var options []ndp.Option
+	// saves hosts the neighbor solicitation to resolve our link-layer address
+	if t.IncludeSLLA && usableHardwareAddr(t.hwAddr) {
+		options = append(options, &ndp.LinkLayerAddress{
+			Direction: ndp.Source,
+			Addr:      t.hwAddr,
+		})
+	}
+	if t.MTU != 0 {
+		options = append(options, ndp.NewMTU(t.MTU))
+	}
+	options = append(options, rdnssOptions(t.DNSServers, t.dnsLifetime())...)
+	if len(t.SearchDomains) > 0 {
+		options = append(options, &ndp.DNSSearchList{
+			Lifetime:    t.dnsLifetime(),
+			DomainNames: t.SearchDomains,
+		})
+	}
+	if t.NAT64Prefix != nil {
+		// RFC 8781 recommends a lifetime of at least 3 * MaxRtrAdvInterval, same as the DNS options
+		options = append(options, pref64Option(t.NAT64Prefix, t.dnsLifetime()))
+	}
+	if t.AdvInterval {
+		options = append(options, advIntervalOption(t.MaxInterval))
+	}
+	if t.CaptivePortalURI != "" {
+		options = append(options, ndp.NewCaptivePortal(t.CaptivePortalURI))
+	}
+	for _, p := range t.advertisedPrefixes() {
+		options = append(options, p.option())
+	}
+	_, _, subnets := t.routes.get()
+
+	if t.AdvertiseSubnetRoutes {
+		for _, s := range subnets {
+			l, _ := s.Mask.Size()
+			// the route preference only applies to this specific route, not to us as default router
+			options = append(options, &ndp.RouteInformation{
+				PrefixLength:  uint8(l),
+				Preference:    t.RoutePreference,
+				RouteLifetime: t.RouteLifetime,
+				Prefix:        s.IP,
+			})
+		}
+	}
+
+	// an additional default route, hosts pick it by its own preference and lifetime (RFC 4191 section 3.1)
+	if t.DefaultRouteLifetime > 0 {
+		options = append(options, &ndp.RouteInformation{
+			PrefixLength:  0,
+			Preference:    t.DefaultRoutePreference,
+			RouteLifetime: t.DefaultRouteLifetime,
+			Prefix:        net.IPv6zero,
+		})
+	}
+
+	// without dns options hosts have to ask DHCPv6 for them
+	other := t.OtherFlag || (t.AutoOtherFlag && len(t.DNSServers) == 0 && len(t.SearchDomains) == 0)
+
+	routerLifetime := t.RouterLifetime
+	// some other device is the default router in these modes
+	if t.Mode == ModeAddressingOnly || t.Mode == ModeOnLinkOnly {
+		routerLifetime = 0
+	}
+
+	return &ndp.RouterAdvertisement{
+		CurrentHopLimit:      t.HopLimit,
+		ManagedConfiguration: t.ManagedFlag,
+		OtherConfiguration:   other,
+		// preference of the default route (RFC 4191 section 2.2), independent of the route information options
+		RouterSelectionPreference: t.Preference,
+		RouterLifetime:            routerLifetime,
+		ReachableTime:             t.ReachableTime,
+		RetransmitTimer:           t.RetransmitTimer,
+		Options:                   options,
+	}
+}
+
+// advertisedPrefixes resolves the current and deprecated prefixes with their flags and lifetimes, one per PIO.
+// the caller has to hold t.lock for reading
+func (t *Tap) advertisedPrefixes() []AdvertisedPrefix {
+	// RAs still go out, so the host gets its default route, but no address within a prefix it can't use yet
+	if t.WaitForHost && !t.hostSeen {
+		return nil
+	}
+	prefixes, _, _ := t.routes.get()
+	preferred := t.PrefixPreferredLifetime
+	if t.draining {
+		preferred = 0
+	}
+	var advertised []AdvertisedPrefix
+	for _, prefix := range prefixes {
+		// retired prefixes go out next to the new ones, hosts stop picking them for new connections
+		if containsIP(t.retired, prefix) {
+			advertised = append(advertised, t.advertisedPrefix(prefix, 0))
+			continue
+		}
+		advertised = append(advertised, t.advertisedPrefix(prefix, preferred))
+	}
+	// prefixes whose route went away are kept until their valid lifetime ran out, but hosts should stop using
+	// them for new connections
+	deprecated, lifetimes := t.routes.expire(t.PrefixValidLifetime)
+	for i, prefix := range deprecated {
+		p := t.advertisedPrefix(prefix, 0)
+		p.ValidLifetime = lifetimes[i]
+		p.Source = PrefixSourceDeprecated
+		advertised = append(advertised, p)
+	}
+	return advertised
+}
+
+// advertisedPrefix applies the flags and lifetimes configured for a /64 prefix
+func (t *Tap) advertisedPrefix(prefix net.IP, preferred time.Duration) AdvertisedPrefix {
+	f := t.prefixFlags(prefix)
+	if t.SuppressAnycastOnLink && f.OnLink && t.routes.holdsAnycast(prefix) {
+		f.OnLink = false
+	}
+	return AdvertisedPrefix{
+		Prefix:            prefix,
+		Length:            64,
+		OnLink:            f.OnLink,
+		Autonomous:        f.Autonomous,
+		RouterAddress:     f.RouterAddress,
+		ValidLifetime:     t.PrefixValidLifetime,
+		PreferredLifetime: preferred,
+		Source:            t.prefixSource(prefix),
+	}
+}
+
+// option builds the prefix information option of the prefix
+func (p AdvertisedPrefix) option() ndp.Option {
+	if p.RouterAddress != nil {
+		return routerAddressPIO(p.flags(), p.ValidLifetime, p.PreferredLifetime)
+	}
+	return &ndp.PrefixInformation{
+		PrefixLength:                   p.Length,
+		OnLink:                         p.OnLink,
+		AutonomousAddressConfiguration: p.Autonomous,
+		ValidLifetime:                  p.ValidLifetime,
+		PreferredLifetime:              p.PreferredLifetime,
+		Prefix:                         p.Prefix,
+	}
+}
+
+// tiggers RouterAdvertisements every Interval duration or when a RouterSolicit was received on the interface.
+// unsolicited RAs run off the periodic timer, solicited ones off their own timer only gated by MIN_DELAY_BETWEEN_RAS,
+// so a long interval doesn't delay answering solicits. any multicast RA sent restarts the periodic timer
+// (RFC 4861 section 6.2.4), unicast ones leave it alone so a soliciting host can't delay the RA to everyone else
+func (t *Tap) sendLoop(ctx context.Context, c ndpConn) error {
+	var m *ndp.RouterAdvertisement
+
+	closed := func(count int) error {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Debugf("%s sender closed, sent: %d advertisements", t.Ifi.Name, count)
+		t.sendFinalRA(c, m)
+		return ctx.Err()
+	}
+
+	count := 0
+	// unsolicitedCount only counts the periodic RAs, solicits must not use up the initial burst
+	unsolicitedCount := 0
+	var lastSent time.Time
+	send := func(to net.IP) error {
+		// rebuilding every time since the prefixes or config may have changed meanwhile
+		t.lock.RLock()
+		m = t.advertisement()
+		dryRun, logContents := t.DryRun, t.LogRAContents
+		t.lock.RUnlock()
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("%s sent RA prefixes %s to %s", t.Ifi.Name, t.Prefixes(), to)
+		if logContents {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("%s RA contents: %s", t.Ifi.Name, summarizeRA(m))
+		}
+		count++
+		if dryRun {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s dry-run RA to %s: %s", t.Ifi.Name, to, formatRA(m))
+		} else {
+			// WriteTo marshals the message as well, so this covers both
+			start := time.Now()
+			err := c.WriteTo(m, nil, to)
+			metricRASendDuration.WithLabelValues(t.Ifi.Name).Observe(time.Since(start).Seconds())
+			if err != nil {
+				return fmt.Errorf("failed to send router advertisement: %w", err)
+			}
+		}
+		metricRASent.WithLabelValues(t.Ifi.Name).Inc()
+		lastSent = time.Now()
+		atomic.StoreInt64(&t.lastRA, lastSent.UnixNano())
+		return nil
+	}
+
+	// periodic fires right away for the initial RA
+	periodic := time.NewTimer(0)
+	defer periodic.Stop()
+	// solicited is nil unless a solicited RA is pending, dst is where it goes (nil = all nodes)
+	var solicited <-chan time.Time
+	var dst net.IP
+
+	// Send messages until cancelation or error.
+	for {
+		var to net.IP
+		unsolicited := false
+		select {
+		case <-ctx.Done():
+			return closed(count)
+		case <-t.linkChange:
+			if !t.LinkUp() {
+				return errLinkDown
+			}
+			continue
+		case <-periodic.C:
+			unsolicited = true
+			unsolicitedCount++
+			to = net.IPv6linklocalallnodes
+			// point-to-point taps with a single known host don't need to bother the rest of the segment
+			t.lock.RLock()
+			if t.UnicastPeriodicTo != nil {
+				to = t.UnicastPeriodicTo
+			}
+			t.lock.RUnlock()
+		case <-solicited:
+			to = net.IPv6linklocalallnodes
+			if dst != nil {
+				to = dst
+			}
+		case d := <-t.rs:
+			if solicited != nil {
+				// any further RS while waiting is coalesced into the pending RA, which then has to reach all of them
+				dst = nil
+				continue
+			}
+			dst = d
+			wait := minDelayBetweenRAs - time.Since(lastSent)
+			if wait > 0 {
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+					Debugf("%s throttling solicited RA, sending in %v", t.Ifi.Name, wait)
+			} else {
+				wait = 0
+			}
+			solicited = time.After(wait)
+			continue
+		}
+
+		if err := send(to); err != nil {
+			return err
+		}
+		// whatever got sent answers a pending solicit as well, unless it was unicast to someone else
+		if solicited != nil && (to.IsMulticast() || to.Equal(dst)) {
+			solicited, dst = nil, nil
+		}
+		// the timer has to be rearmed after it fired, even if the periodic RA went out via unicast
+		if !unsolicited && !to.IsMulticast() {
+			continue
+		}
+
+		t.lock.RLock()
+		// the regular intervals are drawn from MinInterval-MaxInterval, that spreads taps added at once (i.e. on
+		// boot) as well without ever exceeding the MaxInterval hosts are told to expect via the interval option
+		interval := t.nextInterval()
+		initialAdvertisements, initialInterval := t.InitialAdvertisements, t.InitialAdvertInterval
+		t.lock.RUnlock()
+		// the first few RAs go out faster so freshly booted hosts don't have to wait for the regular cadence
+		if unsolicitedCount < initialAdvertisements && interval > initialInterval {
+			interval = initialInterval
+		}
+		if !periodic.Stop() {
+			// the timer may have fired while we were sending
+			select {
+			case <-periodic.C:
+			default:
+			}
+		}
+		periodic.Reset(interval)
+	}
+}
+
+// sendFinalRA tells hosts we are going away by advertising a router lifetime of 0 (RFC 4861 section 6.2.5)
+// this is best effort only, giving up after finalRATimeout makes sure closing the tap isn't blocked by a hung socket
+func (t *Tap) sendFinalRA(c ndpConn, m *ndp.RouterAdvertisement) {
+	// closed before the first RA went out, nobody to tell
+	if m == nil {
+		return
+	}
+	final := *m
+	final.RouterLifetime = 0
+	t.lock.RLock()
+	dryRun := t.DryRun
+	t.lock.RUnlock()
+	if dryRun {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s dry-run final RA: %s", t.Ifi.Name, formatRA(&final))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), finalRATimeout)
+	defer cancel()
+
+	// the deadline alone doesn't help if the socket hangs, so sending in the background.
+	// the connection getting closed afterwards unblocks it in any case
+	sent := make(chan error, 1)
+	go func() {
+		if err := c.SetWriteDeadline(time.Now().Add(finalRATimeout)); err != nil {
+			sent <- fmt.Errorf("unable to set deadline: %v", err)
+			return
+		}
+		sent <- c.WriteTo(&final, nil, net.IPv6linklocalallnodes)
+	}()
+
+	var err error
+	select {
+	case err = <-sent:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("failed to send final router advertisement, hosts may keep a stale default route: %v", err)
+		return
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("%s sent final RA with zero router lifetime", t.Ifi.Name)
+}
+
+// errRetry tells the receive loop to go on reading
+var errRetry = errors.New("retry")
+
+// errLinkDown stops advertising until the link is up again
+var errLinkDown = errors.New("link down")
+
+// errDialTimeout gives up on a tap which couldn't be dialed within its dial timeout
+var errDialTimeout = errors.New("dial timeout")
+
+// transientSendError tells if sending failed due to the interface being unavailable for a moment,
+// as opposed to errors which won't go away by re-dialing
+func transientSendError(err error) bool {
+	for _, e := range []error{unix.ENETDOWN, unix.ENETUNREACH, unix.ENXIO, unix.ENODEV, unix.EADDRNOTAVAIL, unix.ENOBUFS} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatRA renders the RA header and all its options for logging
+func formatRA(m *ndp.RouterAdvertisement) string {
+	s := fmt.Sprintf(
+		"hoplimit=%d managed=%v other=%v preference=%v router-lifetime=%v reachable=%v retransmit=%v",
+		m.CurrentHopLimit,
+		m.ManagedConfiguration,
+		m.OtherConfiguration,
+		m.RouterSelectionPreference,
+		m.RouterLifetime,
+		m.ReachableTime,
+		m.RetransmitTimer,
+	)
+	return s + formatOptions(m.Options)
+}
+
+// formatOptions renders ndp options for logging, each one prefixed by a space
+func formatOptions(options []ndp.Option) string {
+	var s string
+	for _, o := range options {
+		switch o := o.(type) {
+		case *ndp.MTU:
+			// a pointer to a plain integer would only print its address
+			s += fmt.Sprintf(" mtu=%d", *o)
+		default:
+			s += fmt.Sprintf(" %T%+v", o, o)
+		}
+	}
+	return s
+}
+
+// summarizeRA renders the gist of an RA in one line: prefixes, mtu, number of dns servers, flags and lifetimes
+func summarizeRA(m *ndp.RouterAdvertisement) string {
+	var prefixes, flags []string
+	var mtu uint32
+	dns := 0
+	for _, o := range m.Options {
+		switch o := o.(type) {
+		case *ndp.PrefixInformation:
+			prefixes = append(prefixes, fmt.Sprintf("%s/%d(%v/%v)", o.Prefix, o.PrefixLength, o.ValidLifetime, o.PreferredLifetime))
+		case *ndp.MTU:
+			mtu = uint32(*o)
+		case *ndp.RecursiveDNSServer:
+			dns += len(o.Servers)
+		}
+	}
+	if m.ManagedConfiguration {
+		flags = append(flags, "M")
+	}
+	if m.OtherConfiguration {
+		flags = append(flags, "O")
+	}
+	return fmt.Sprintf(
+		"prefixes=[%s] mtu=%d dns=%d flags=[%s] router-lifetime=%v options=%d",
+		strings.Join(prefixes, " "),
+		mtu,
+		dns,
+		strings.Join(flags, ","),
+		m.RouterLifetime,
+		len(m.Options),
+	)
+}
+
+// dnsLifetime is the lifetime used for the DNS options, RFC 8106 recommends at least 3 * MaxRtrAdvInterval
+func (t *Tap) dnsLifetime() time.Duration {
+	if t.DNSLifetime != 0 {
+		return t.DNSLifetime
+	}
+	return 3 * t.MaxInterval
+}
+
+// nextInterval picks a random delay between MinInterval and MaxInterval as described in RFC 4861 section 6.2.4
+// it is recomputed for every unsolicited RA so taps don't synchronize with each other
+func (t *Tap) nextInterval() time.Duration {
+	spread := t.MaxInterval - t.MinInterval
+	if spread <= 0 {
+		return t.MaxInterval
+	}
+	return t.MinInterval + time.Duration(rand.Int63n(int64(spread)+1))
+}
+
+// receiveLoop endlessly checks for RouterSolicits while also checking if Context has been cancelled
+func (t *Tap) receiveLoop(ctx context.Context, c ndpConn) error {
+	count := 0
+	limiter := newRSLimiter(rsSourceWindow)
+	for {
+		select {
+		case <-ctx.Done():
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+				Debugf("%s listener closed, received: %d solicits", t.Ifi.Name, count)
+			return ctx.Err()
+		default:
+		}
+
+		msg, from, err := receiveRS(c)
+		switch err {
+		case errRetry:
+			continue
+		case nil:
+			// looped back packets of our own are no solicits to answer
+			if from.Equal(t.addr) {
+				continue
+			}
+			if ra, ok := msg.(*ndp.RouterAdvertisement); ok {
+				t.competingRouter(ra, from)
+				continue
+			}
+			t.sawHost(from)
+			if ns, ok := msg.(*ndp.NeighborSolicitation); ok {
+				t.answerNS(c, ns, from)
+				continue
+			}
+			count++
+			atomic.AddInt64(&t.rsCount, 1)
+			metricRSReceived.WithLabelValues(t.Ifi.Name).Inc()
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String(), "SourceLLA": sourceLLA(msg)}).
+				Debugf("%s received RS from %s (%s)", t.Ifi.Name, from, sourceLLA(msg))
+			t.lock.RLock()
+			observe := t.Observe
+			t.lock.RUnlock()
+			if observe {
+				var options []ndp.Option
+				if rs, ok := msg.(*ndp.RouterSolicitation); ok {
+					options = rs.Options
+				}
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String(), "SourceLLA": sourceLLA(msg)}).
+					Infof("%s observed RS from %s:%s", t.Ifi.Name, from, formatOptions(options))
+				continue
+			}
+			t.lock.RLock()
+			force, maxPerSource, respond := t.ForceMulticastResponse, t.MaxRSPerSource, t.RespondToRS
+			t.lock.RUnlock()
+			// solicits may only be let through to see if a host is up
+			if !respond {
+				continue
+			}
+			// hosts without an address yet all solicit from ::, limiting those would hit the legitimate ones as well
+			if maxPerSource > 0 && !from.IsUnspecified() && !limiter.allow(from, maxPerSource, time.Now()) {
+				metricRSSuppressed.WithLabelValues(t.Ifi.Name).Inc()
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+					Debugf("%s ignoring RS from %s, more than %d per %v", t.Ifi.Name, from, maxPerSource, rsSourceWindow)
+				continue
+			}
+			// solicits from a unicast source with its link-layer address can be answered directly (RFC 4861 section 6.2.6)
+			var dst net.IP
+			if !from.IsUnspecified() && sourceLLA(msg) != "" {
+				dst = from
+			}
+			if dst != nil && force {
+				ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+					Debugf("%s answering RS from %s via multicast, forced by config", t.Ifi.Name, from)
+				dst = nil
+			}
+			// the reader must keep up with the socket, solicits coming in faster than RAs go out share one
+			if !t.trigger(dst) {
+				metricRSCoalesced.WithLabelValues(t.Ifi.Name).Inc()
+			}
+		default:
+			return err
+		}
+	}
+}
+
+// trigger requests an RA, unicast to dst or to all nodes if dst is nil.
+// it never blocks, if an RA is pending already the two are merged into a multicast one and false is returned
+func (t *Tap) trigger(dst net.IP) bool {
+	select {
+	case t.rs <- dst:
+		return true
+	default:
+	}
+
+	// the pending RA has to reach both, so turning it into a multicast one
+	select {
+	case <-t.rs:
+	default:
+	}
+	select {
+	case t.rs <- nil:
+	default:
+	}
+	return false
+}
+
+// sourceLLA returns the source link-layer address option of a solicit, empty if not present
+func sourceLLA(msg ndp.Message) string {
+	rs, ok := msg.(*ndp.RouterSolicitation)
+	if !ok {
+		return ""
+	}
+	for _, o := range rs.Options {
+		if lla, ok := o.(*ndp.LinkLayerAddress); ok && lla.Direction == ndp.Source {
+			return lla.Addr.String()
+		}
+	}
+	return ""
+}
+
+// competingRouter warns about an other router advertising on the tap, hosts may pick up its default route or
+// prefixes instead of ours. only the first RA and changes are warned about, RAs are only received if accepted
+// by the ICMP filter (AcceptICMP)
+func (t *Tap) competingRouter(ra *ndp.RouterAdvertisement, from net.IP) {
+	var prefixes []string
+	for _, o := range ra.Options {
+		if p, ok := o.(*ndp.PrefixInformation); ok {
+			prefixes = append(prefixes, fmt.Sprintf("%s/%d", p.Prefix, p.PrefixLength))
+		}
+	}
+	seen := fmt.Sprintf("router lifetime %v, prefixes %s", ra.RouterLifetime, prefixes)
+	log := ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()})
+	if t.competitors[from.String()] == seen {
+		log.Debugf("%s RA from other router %s: %s", t.Ifi.Name, from, seen)
+		return
+	}
+	t.competitors[from.String()] = seen
+	log.Warnf("%s other router %s is advertising on this tap: %s", t.Ifi.Name, from, seen)
+}
+
+// answerNS sends a neighbor advertisement if ns is for our link-local or a subnet-router anycast address we hold
+// (RFC 4861 section 7.2.4), other targets are left to the kernel
+func (t *Tap) answerNS(c ndpConn, ns *ndp.NeighborSolicitation, from net.IP) {
+	t.lock.RLock()
+	addr, dryRun, respond, hw, observe := t.addr, t.DryRun, t.RespondToNS, t.hwAddr, t.Observe
+	t.lock.RUnlock()
+	// solicits may be let through by AcceptICMP as well
+	if !respond {
+		return
+	}
+	// observing never advertises us as router, that includes the router flag of NAs
+	if observe {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+			Debugf("%s observed NS for %s from %s", t.Ifi.Name, ns.TargetAddress, from)
+		return
+	}
+	anycast := t.routes.holdsAnycast(ns.TargetAddress)
+	if !ns.TargetAddress.Equal(addr) && !anycast {
+		return
+	}
+
+	na := &ndp.NeighborAdvertisement{
+		Router:    true,
+		Solicited: !from.IsUnspecified(),
+		// other holders of an anycast address must not be overridden
+		Override:      !anycast,
+		TargetAddress: ns.TargetAddress,
+	}
+	if usableHardwareAddr(hw) {
+		na.Options = append(na.Options, &ndp.LinkLayerAddress{Direction: ndp.Target, Addr: hw})
+	}
+	// a solicit from the unspecified address (DAD) is answered to all nodes
+	dst := from
+	if from.IsUnspecified() {
+		dst = net.IPv6linklocalallnodes
+	}
+
+	if dryRun {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+			Infof("%s dry-run NA for %s to %s", t.Ifi.Name, ns.TargetAddress, dst)
+		return
+	}
+	if err := c.WriteTo(na, nil, dst); err != nil {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+			Warnf("%s failed answering NS for %s: %v", t.Ifi.Name, ns.TargetAddress, err)
+		return
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+		Debugf("%s sent NA for %s to %s", t.Ifi.Name, ns.TargetAddress, dst)
+}
+
+// receiveRS reads RouterSolicits, and NeighborSolicits or RouterAdvertisements if the ICMP filter lets them through,
+// but tries to keep it brief
+func receiveRS(c ndpConn) (ndp.Message, net.IP, error) {
+	if err := c.SetReadDeadline(time.Now().Add(1 * time.Second)); err != nil {
+		return nil, nil, fmt.Errorf("failed to set deadline: %v", err)
+	}
+
+	msg, _, from, err := c.ReadFrom()
+	if err == nil {
+		ll.Tracef("received %d...", msg.Type())
+		switch msg.Type() {
+		case ipv6.ICMPTypeRouterSolicitation, ipv6.ICMPTypeNeighborSolicitation, ipv6.ICMPTypeRouterAdvertisement:
+		default:
+			// Read a message, but it isn't a solicit.  Keep trying. only accepted on request, so worth a note
+			ll.WithFields(ll.Fields{"Source": from.String()}).Debugf("ignoring %v from %s", msg.Type(), from)
+			return nil, nil, errRetry
+		}
+
+		// Got a Solicit, or an RA of an other router
+		return msg, from, nil
+	}
+
+	// Was the error caused by a read timeout, and should the loop continue?
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return nil, nil, errRetry
+	}
+
+	return nil, nil, fmt.Errorf("failed to read message: %v", err)
+}
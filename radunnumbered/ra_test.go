@@ -0,0 +1,263 @@
+package radunnumbered
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"golang.org/x/net/ipv6"
+)
+
+// fakeMsg is a message read from or written to a fakeConn along with the peer address
+type fakeMsg struct {
+	m    ndp.Message
+	addr net.IP
+}
+
+// fakeConn is an ndpConn handing out the messages queued to in and capturing the ones written in out
+type fakeConn struct {
+	in     chan fakeMsg
+	out    chan fakeMsg
+	closed chan struct{}
+	once   sync.Once
+	closes int32
+
+	lock     sync.Mutex
+	deadline time.Time
+	filter   *ipv6.ICMPFilter
+	groups   []net.IP
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		in:     make(chan fakeMsg, 8),
+		out:    make(chan fakeMsg, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+// errFakeTimeout is returned by ReadFrom once the read deadline passed, like a real socket would
+type errFakeTimeout struct{}
+
+func (errFakeTimeout) Error() string   { return "i/o timeout" }
+func (errFakeTimeout) Timeout() bool   { return true }
+func (errFakeTimeout) Temporary() bool { return true }
+
+var errFakeClosed = errors.New("use of closed fake conn")
+
+func (c *fakeConn) ReadFrom() (ndp.Message, *ipv6.ControlMessage, net.IP, error) {
+	c.lock.Lock()
+	deadline := c.deadline
+	c.lock.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case msg := <-c.in:
+		return msg.m, nil, msg.addr, nil
+	case <-timeout:
+		return nil, nil, nil, errFakeTimeout{}
+	case <-c.closed:
+		return nil, nil, nil, errFakeClosed
+	}
+}
+
+func (c *fakeConn) WriteTo(m ndp.Message, cm *ipv6.ControlMessage, dst net.IP) error {
+	select {
+	case c.out <- fakeMsg{m: m, addr: dst}:
+		return nil
+	case <-c.closed:
+		return errFakeClosed
+	}
+}
+
+func (c *fakeConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.deadline = t
+	return nil
+}
+
+func (c *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *fakeConn) SetICMPFilter(f *ipv6.ICMPFilter) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.filter = f
+	return nil
+}
+
+func (c *fakeConn) JoinGroup(group net.IP) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.groups = append(c.groups, group)
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	atomic.AddInt32(&c.closes, 1)
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// waitRA returns the first RA written to dst, skipping the ones to other destinations
+func waitRA(t *testing.T, c *fakeConn, dst net.IP, timeout time.Duration) *ndp.RouterAdvertisement {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		select {
+		case msg := <-c.out:
+			ra, ok := msg.m.(*ndp.RouterAdvertisement)
+			if ok && msg.addr.Equal(dst) {
+				return ra
+			}
+		case <-deadline:
+			t.Fatalf("no RA to %s within %v", dst, timeout)
+			return nil
+		}
+	}
+}
+
+// stubListen replaces ndpListen for the test, restoring it afterwards
+func stubListen(t *testing.T, listen func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error)) {
+	t.Helper()
+	orig := ndpListen
+	ndpListen = listen
+	t.Cleanup(func() { ndpListen = orig })
+}
+
+// isClosed tells if Close got called at least once
+func (c *fakeConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closes) > 0
+}
+
+func TestDoRAAnswersRS(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	hostMAC := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x02}
+	host := net.ParseIP("fe80::2")
+	dns := net.ParseIP("2001:db8::53")
+
+	tap := newTestTap(t, TapConfig{
+		MTU:        1400,
+		DNSServers: []DNSServer{{Addr: dns}},
+	}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	tap.lock.Lock()
+	tap.hwAddr = mac
+	tap.addr = net.ParseIP("fe80::1")
+	tap.linkDown = false
+	tap.lock.Unlock()
+
+	c := newFakeConn()
+	done := make(chan error, 1)
+	go func() { done <- tap.doRA(c) }()
+	// an RS racing the initial RA would be answered by it, via multicast
+	waitRA(t, c, net.IPv6linklocalallnodes, time.Second)
+
+	c.in <- fakeMsg{
+		m: &ndp.RouterSolicitation{Options: []ndp.Option{
+			&ndp.LinkLayerAddress{Direction: ndp.Source, Addr: hostMAC},
+		}},
+		addr: host,
+	}
+	// the solicited RA is held back by MIN_DELAY_BETWEEN_RAS after the initial one
+	ra := waitRA(t, c, host, 2*minDelayBetweenRAs)
+
+	var pio *ndp.PrefixInformation
+	var mtu *ndp.MTU
+	var rdnss *ndp.RecursiveDNSServer
+	var slla *ndp.LinkLayerAddress
+	for _, o := range ra.Options {
+		switch o := o.(type) {
+		case *ndp.PrefixInformation:
+			pio = o
+		case *ndp.MTU:
+			mtu = o
+		case *ndp.RecursiveDNSServer:
+			rdnss = o
+		case *ndp.LinkLayerAddress:
+			slla = o
+		}
+	}
+	if pio == nil || !pio.Prefix.Equal(net.ParseIP("2001:db8:1::")) || pio.PrefixLength != 64 {
+		t.Errorf("prefix information %+v, want 2001:db8:1::/64", pio)
+	} else if !pio.AutonomousAddressConfiguration || pio.OnLink {
+		t.Errorf("prefix flags autonomous %v on-link %v, want SLAAC off-link", pio.AutonomousAddressConfiguration, pio.OnLink)
+	}
+	if mtu == nil || *mtu != 1400 {
+		t.Errorf("mtu option %v, want 1400", mtu)
+	}
+	if rdnss == nil || len(rdnss.Servers) != 1 || !rdnss.Servers[0].Equal(dns) {
+		t.Errorf("rdnss option %+v, want %s", rdnss, dns)
+	}
+	if slla == nil || slla.Direction != ndp.Source || slla.Addr.String() != mac.String() {
+		t.Errorf("source link-layer address option %+v, want %s", slla, mac)
+	}
+	if ra.RouterLifetime == 0 {
+		t.Error("router lifetime 0, want a default router")
+	}
+
+	tap.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("doRA returned %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(finalRATimeout + time.Second):
+		t.Fatal("doRA didn't return after closing the tap")
+	}
+}
+
+func TestFirstRegularRAWithinMaxInterval(t *testing.T) {
+	tap := newTestTap(t, TapConfig{
+		MinInterval:           3 * time.Second,
+		MaxInterval:           4 * time.Second,
+		InitialAdvertisements: 1,
+	}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	tap.lock.Lock()
+	tap.addr = net.ParseIP("fe80::1")
+	tap.linkDown = false
+	tap.lock.Unlock()
+
+	c := newFakeConn()
+	go tap.doRA(c)
+	waitRA(t, c, net.IPv6linklocalallnodes, time.Second)
+	start := time.Now()
+	// hosts are told to expect an RA within MaxInterval, the spread of the first regular one must stay inside it
+	waitRA(t, c, net.IPv6linklocalallnodes, 4*time.Second+500*time.Millisecond)
+	if took := time.Since(start); took < 3*time.Second-100*time.Millisecond {
+		t.Errorf("first regular RA after %v, want at least MinInterval 3s", took)
+	}
+}
+
+func TestObserveSendsNothing(t *testing.T) {
+	tap := newTestTap(t, TapConfig{
+		Observe:     true,
+		RespondToNS: true,
+		AcceptICMP:  []string{"neighbor-solicitation"},
+	}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	tap.lock.Lock()
+	tap.hwAddr = net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+	tap.addr = net.ParseIP("fe80::1")
+	tap.linkDown = false
+	tap.lock.Unlock()
+
+	c := newFakeConn()
+	go tap.doRA(c)
+	c.in <- fakeMsg{m: &ndp.RouterSolicitation{}, addr: net.ParseIP("fe80::2")}
+	c.in <- fakeMsg{m: &ndp.NeighborSolicitation{TargetAddress: net.ParseIP("fe80::1")}, addr: net.ParseIP("fe80::2")}
+
+	select {
+	case msg := <-c.out:
+		t.Errorf("observing tap sent %T to %s", msg.m, msg.addr)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,55 @@
+package radunnumbered
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	// rsSourceWindow is the period MaxRSPerSource applies to
+	rsSourceWindow = time.Minute
+	// rsMaxSources caps the sources tracked at once, solicits from further ones are not limited
+	rsMaxSources = 4096
+)
+
+// rsLimiter counts the solicits per source address in fixed windows, sources expire once their window passed.
+// only used by the receive loop of a tap, so no locking
+type rsLimiter struct {
+	window  time.Duration
+	sources map[string]*rsSource
+	pruned  time.Time
+}
+
+// rsSource is the number of solicits of one source since the start of its window
+type rsSource struct {
+	since time.Time
+	count int
+}
+
+func newRSLimiter(window time.Duration) *rsLimiter {
+	return &rsLimiter{window: window, sources: map[string]*rsSource{}}
+}
+
+// allow counts a solicit from src at now, false once src sent more than max within the window
+func (l *rsLimiter) allow(src net.IP, max int, now time.Time) bool {
+	if now.Sub(l.pruned) >= l.window {
+		for key, s := range l.sources {
+			if now.Sub(s.since) >= l.window {
+				delete(l.sources, key)
+			}
+		}
+		l.pruned = now
+	}
+
+	key := src.String()
+	s, ok := l.sources[key]
+	if !ok || now.Sub(s.since) >= l.window {
+		if !ok && len(l.sources) >= rsMaxSources {
+			return true
+		}
+		s = &rsSource{since: now}
+		l.sources[key] = s
+	}
+	s.count++
+	return s.count <= max
+}
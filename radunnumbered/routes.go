@@ -0,0 +1,373 @@
+package radunnumbered
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ll "github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// LinkReady will return true when its ok to bind the ndp listener to it.
+// it will wait for the TX counter to start incrementing since before thats the case
+// there are certain aspects not fulfilled. (i.e. link local may not yet be assinged etc
+// it will also help on edge cases where the interface is not yet fully provisioned even though up
+func LinkReady(l *netlink.LinkAttrs) bool {
+	if LinkUp(l) {
+		if l.Statistics != nil && l.Statistics.TxPackets > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkUp tells if the link is administratively up and has carrier
+func LinkUp(l *netlink.LinkAttrs) bool {
+	return l.OperState == netlink.OperUp && l.Flags&net.FlagUp == net.FlagUp
+}
+
+// linkUpByIndex looks up the link state via netlink, false if the link is unknown
+func linkUpByIndex(ifIdx int) bool {
+	link, err := netlink.LinkByIndex(ifIdx)
+	if err != nil {
+		return false
+	}
+	return LinkUp(link.Attrs())
+}
+
+// linkHardwareAddr looks up the current MAC of the link via netlink. for a VLAN subinterface inheriting the MAC of
+// its parent, the parent's name is returned as well
+func linkHardwareAddr(ifIdx int) (net.HardwareAddr, string, error) {
+	link, err := netlink.LinkByIndex(ifIdx)
+	if err != nil {
+		return nil, "", err
+	}
+	attrs := link.Attrs()
+	if _, ok := link.(*netlink.Vlan); !ok {
+		return attrs.HardwareAddr, "", nil
+	}
+	parent, err := netlink.LinkByIndex(attrs.ParentIndex)
+	if err != nil || !bytes.Equal(parent.Attrs().HardwareAddr, attrs.HardwareAddr) {
+		return attrs.HardwareAddr, "", nil
+	}
+	return attrs.HardwareAddr, parent.Attrs().Name, nil
+}
+
+// RouteProvider looks up the routes pointing to an interface, broken out in host routes (/128) and subnet routes
+type RouteProvider interface {
+	Routes(ifIdx int) (hostRoutes []*net.IPNet, subnets []*net.IPNet, err error)
+}
+
+// NetlinkRoutes is the default RouteProvider reading the routing table of the current netns
+type NetlinkRoutes struct{}
+
+// Routes implements RouteProvider
+func (NetlinkRoutes) Routes(ifIdx int) ([]*net.IPNet, []*net.IPNet, error) {
+	return getHostRoutesIpv6(ifIdx)
+}
+
+// excludeRoutes drops the routes within exclude
+func excludeRoutes(routes []*net.IPNet, exclude []net.IPNet) []*net.IPNet {
+	var kept []*net.IPNet
+	for _, r := range routes {
+		match := false
+		for _, e := range exclude {
+			if e.Contains(r.IP) {
+				match = true
+			}
+		}
+		if !match {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// excludePrefixes drops the /64 prefixes overlapping exclude, hosts would otherwise configure addresses within it
+func excludePrefixes(ifName string, prefixes []net.IP, exclude []net.IPNet) []net.IP {
+	var kept []net.IP
+	for _, p := range prefixes {
+		prefix := &net.IPNet{IP: p, Mask: net.CIDRMask(64, 128)}
+		skip := false
+		for _, e := range exclude {
+			if e.Contains(p) || prefix.Contains(e.IP) {
+				ll.WithFields(ll.Fields{"Interface": ifName}).Infof("skipping prefix %s, excluded by %s", prefix, &e)
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// getHostRoutesIpv6 finds all routes for a interfaces and returns them broken out in host routes and subnet routes
+func getHostRoutesIpv6(ifIdx int) ([]*net.IPNet, []*net.IPNet, error) {
+	nlh, err := netlink.NewHandle()
+	defer nlh.Delete()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to hook into netlink: %v", err)
+	}
+
+	link, err := netlink.LinkByIndex(ifIdx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get link info: %v", err)
+	}
+
+	ro, err := nlh.RouteList(link, 6)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get routes: %v", err)
+	}
+
+	var hr []*net.IPNet
+	var sr []*net.IPNet
+	for _, d := range ro {
+		// the same destination may show up multiple times, i.e. with different metrics
+		m, l := d.Dst.Mask.Size()
+		if m == 128 && l == 128 {
+			if !containsNet(hr, d.Dst) {
+				hr = append(hr, d.Dst)
+			}
+		} else if l == 128 && !d.Dst.IP.IsLinkLocalUnicast() {
+			if !containsNet(sr, d.Dst) {
+				sr = append(sr, d.Dst)
+			}
+		}
+	}
+	return hr, sr, nil
+}
+
+// documentationNet is reserved for examples (RFC 3849), advertising it is most likely a mistake
+var documentationNet = &net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(32, 128)}
+
+// prefixesFromHostRoutes derives the unique /64 prefixes (bits 65-128 set to 0) for SLAAC from the host routes.
+// prefixes which are not usable for SLAAC (link-local, multicast, loopback) are skipped
+func prefixesFromHostRoutes(ifName string, hostRoutes []*net.IPNet) []net.IP {
+	// setting a /64 prefix since thats what I need for the SLAAC advertisements
+	prefixMask := net.CIDRMask(64, 128)
+
+	var prefixes []net.IP
+	for _, hr := range hostRoutes {
+		p := hr.IP.Mask(prefixMask)
+		if containsIP(prefixes, p) {
+			continue
+		}
+		if err := checkPrefix(p); err != nil {
+			ll.WithFields(ll.Fields{"Interface": ifName}).Warnf("skipping host route %s: %v", hr, err)
+			continue
+		}
+		if documentationNet.Contains(p) {
+			ll.WithFields(ll.Fields{"Interface": ifName}).Warnf("advertising documentation prefix %s/64", p)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// prefix classifications as returned by classifyPrefix
+const (
+	prefixGUA       = "gua"
+	prefixULA       = "ula"
+	prefixLinkLocal = "link-local"
+	prefixOther     = "other"
+)
+
+// ulaNet is the unique local address range (RFC 4193)
+var ulaNet = &net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}
+
+// classifyPrefix tells if a prefix is global unicast, unique local or link-local
+func classifyPrefix(p net.IP) string {
+	switch {
+	case p.To4() != nil:
+		return prefixOther
+	case ulaNet.Contains(p):
+		return prefixULA
+	case p.IsLinkLocalUnicast():
+		return prefixLinkLocal
+	case p.IsGlobalUnicast():
+		return prefixGUA
+	}
+	return prefixOther
+}
+
+// checkPrefix verifies the prefix is a global unicast (or ULA) one
+func checkPrefix(p net.IP) error {
+	switch {
+	case p.To4() != nil:
+		return fmt.Errorf("%s is not ipv6", p)
+	case p.IsMulticast():
+		return fmt.Errorf("%s is multicast", p)
+	case p.IsLoopback(), p.IsUnspecified():
+		return fmt.Errorf("%s is loopback or unspecified", p)
+	}
+	switch c := classifyPrefix(p); c {
+	case prefixGUA, prefixULA:
+		return nil
+	default:
+		return fmt.Errorf("%s is %s", p, c)
+	}
+}
+
+// routeState holds the route derived state of a tap, it is updated from the netlink route feed while the tap is running
+type routeState struct {
+	lock sync.RWMutex
+	// ips are the host routes the prefixes are derived from
+	ips        []*net.IPNet
+	prefixes   []net.IP
+	deprecated []net.IP
+	// deprecatedAt is when the route of each deprecated prefix went away, keyed by prefix
+	deprecatedAt map[string]time.Time
+	subnets      []*net.IPNet
+	// anycast are the prefixes whose subnet-router anycast address is assigned to the tap
+	anycast []net.IP
+}
+
+// get returns the current prefixes, the deprecated prefixes and subnets thread safe
+func (r *routeState) get() ([]net.IP, []net.IP, []*net.IPNet) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.prefixes, r.deprecated, r.subnets
+}
+
+// hostRoutes returns the host routes the prefixes got derived from thread safe
+func (r *routeState) hostRoutes() []*net.IPNet {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.ips
+}
+
+// update replaces the host routes, prefixes and subnets. prefixes no longer present are kept as deprecated
+// so they can be advertised with a preferred lifetime of 0 instead of vanishing abruptly, until expire drops them.
+// it returns true if anything changed
+func (r *routeState) update(ips []*net.IPNet, prefixes []net.IP, subnets []*net.IPNet) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	changed := len(subnets) != len(r.subnets)
+	for i := 0; !changed && i < len(subnets); i++ {
+		changed = subnets[i].String() != r.subnets[i].String()
+	}
+
+	var deprecated []net.IP
+	for _, p := range append(r.prefixes, r.deprecated...) {
+		if !containsIP(prefixes, p) && !containsIP(deprecated, p) {
+			deprecated = append(deprecated, p)
+		}
+	}
+	deprecatedAt := make(map[string]time.Time, len(deprecated))
+	for _, p := range deprecated {
+		at, ok := r.deprecatedAt[p.String()]
+		if !ok {
+			at = time.Now()
+		}
+		deprecatedAt[p.String()] = at
+	}
+	if len(prefixes) != len(r.prefixes) || len(deprecated) != len(r.deprecated) {
+		changed = true
+	}
+	for _, p := range prefixes {
+		if !containsIP(r.prefixes, p) {
+			changed = true
+		}
+	}
+
+	r.ips = ips
+	r.prefixes = prefixes
+	r.deprecated = deprecated
+	r.deprecatedAt = deprecatedAt
+	r.subnets = subnets
+	return changed
+}
+
+// expire drops the deprecated prefixes whose valid lifetime ran out since their route went away. it returns the
+// remaining ones along with the valid lifetime they have left, counted down like hosts do (RFC 4862 5.5.3e)
+func (r *routeState) expire(valid time.Duration) ([]net.IP, []time.Duration) {
+	// an infinite lifetime never runs out, withdrawn prefixes have to go away nonetheless
+	if valid >= maxOptionLifetime {
+		valid = DefaultPrefixValidLifetime
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	var (
+		kept      []net.IP
+		lifetimes []time.Duration
+	)
+	for _, p := range r.deprecated {
+		left := valid - time.Since(r.deprecatedAt[p.String()])
+		if left <= 0 {
+			delete(r.deprecatedAt, p.String())
+			continue
+		}
+		kept = append(kept, p)
+		lifetimes = append(lifetimes, left)
+	}
+	r.deprecated = kept
+	return kept, lifetimes
+}
+
+// setAnycast replaces the prefixes the tap holds the subnet-router anycast address of, it returns true if they changed
+func (r *routeState) setAnycast(anycast []net.IP) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	changed := len(anycast) != len(r.anycast)
+	for _, p := range anycast {
+		if !containsIP(r.anycast, p) {
+			changed = true
+		}
+	}
+	r.anycast = anycast
+	return changed
+}
+
+// holdsAnycast checks if the tap holds the subnet-router anycast address of prefix thread safe
+func (r *routeState) holdsAnycast(prefix net.IP) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return containsIP(r.anycast, prefix)
+}
+
+// subnetAnycasts returns the prefixes whose subnet-router anycast address (the prefix with an all zero interface id,
+// RFC 4291 section 2.6.1) is assigned to ifi
+func subnetAnycasts(ifi *net.Interface, prefixes []net.IP) []net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	var anycast []net.IP
+	for _, a := range addrs {
+		n, ok := a.(*net.IPNet)
+		if !ok || n.IP.To4() != nil {
+			continue
+		}
+		if containsIP(prefixes, n.IP) && !containsIP(anycast, n.IP) {
+			anycast = append(anycast, n.IP)
+		}
+	}
+	return anycast
+}
+
+// containsNet checks if n is in nets
+func containsNet(nets []*net.IPNet, n *net.IPNet) bool {
+	for _, i := range nets {
+		if i.IP.Equal(n.IP) && i.Mask.String() == n.Mask.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// containsIP checks if ip is in ips
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
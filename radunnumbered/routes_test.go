@@ -0,0 +1,223 @@
+package radunnumbered
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRoutes is a RouteProvider handing out fixed routes, counting the lookups
+type fakeRoutes struct {
+	hostRoutes []*net.IPNet
+	subnets    []*net.IPNet
+	err        error
+	calls      int32
+}
+
+// Routes implements RouteProvider
+func (f *fakeRoutes) Routes(ifIdx int) ([]*net.IPNet, []*net.IPNet, error) {
+	atomic.AddInt32(&f.calls, 1)
+	return f.hostRoutes, f.subnets, f.err
+}
+
+// cidrs parses the subnets, panicking on typos in the test tables
+func cidrs(subnets ...string) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range subnets {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// loopback returns the interface test taps are created on, every test environment has one
+func loopback(t *testing.T) *net.Interface {
+	t.Helper()
+	ifis, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("unable to list interfaces: %v", err)
+	}
+	for i := range ifis {
+		if ifis[i].Flags&net.FlagLoopback != 0 {
+			return &ifis[i]
+		}
+	}
+	t.Skip("no loopback interface")
+	return nil
+}
+
+// newTestTap creates a tap on the loopback interface with its routes looked up from p, closed with the test
+func newTestTap(t *testing.T, cfg TapConfig, p RouteProvider) *Tap {
+	t.Helper()
+	tap, err := NewTap(loopback(t).Index, WithConfig(cfg), WithRouteProvider(p))
+	if err != nil {
+		t.Fatalf("NewTap failed: %v", err)
+	}
+	t.Cleanup(tap.Close)
+	return tap
+}
+
+func TestNewTapPrefixSelection(t *testing.T) {
+	tests := []struct {
+		name       string
+		hostRoutes []*net.IPNet
+		subnets    []*net.IPNet
+		exclude    []*net.IPNet
+		static     *net.IPNet
+		want       []string
+		wantIPs    []string
+		wantSubnet []string
+	}{
+		{
+			name:       "host routes deduplicated to their /64",
+			hostRoutes: cidrs("2001:db8:1::5/128", "2001:db8:1::6/128", "2001:db8:2::5/128"),
+			want:       []string{"2001:db8:1::", "2001:db8:2::"},
+			wantIPs:    []string{"2001:db8:1::5/128", "2001:db8:1::6/128", "2001:db8:2::5/128"},
+		},
+		{
+			name:       "ula kept like gua",
+			hostRoutes: cidrs("fd00:1::5/128"),
+			want:       []string{"fd00:1::"},
+			wantIPs:    []string{"fd00:1::5/128"},
+		},
+		{
+			name:       "link-local, multicast and loopback rejected",
+			hostRoutes: cidrs("fe80::5/128", "ff02::1/128", "::1/128", "2001:db8:1::5/128"),
+			want:       []string{"2001:db8:1::"},
+			wantIPs:    []string{"fe80::5/128", "ff02::1/128", "::1/128", "2001:db8:1::5/128"},
+		},
+		{
+			name:       "subnets don't derive prefixes",
+			hostRoutes: cidrs("2001:db8:1::5/128"),
+			subnets:    cidrs("2001:db8:9::/64"),
+			want:       []string{"2001:db8:1::"},
+			wantIPs:    []string{"2001:db8:1::5/128"},
+			wantSubnet: []string{"2001:db8:9::/64"},
+		},
+		{
+			name:       "excluded prefix and its routes dropped",
+			hostRoutes: cidrs("2001:db8:1::5/128", "2001:db8:2::5/128"),
+			subnets:    cidrs("2001:db8:2::/64", "2001:db8:9::/64"),
+			exclude:    cidrs("2001:db8:2::/48"),
+			want:       []string{"2001:db8:1::"},
+			wantIPs:    []string{"2001:db8:1::5/128"},
+			wantSubnet: []string{"2001:db8:9::/64"},
+		},
+		{
+			name:       "exclude within a /64 drops the whole prefix",
+			hostRoutes: cidrs("2001:db8:1::5/128", "2001:db8:2::5/128"),
+			exclude:    cidrs("2001:db8:2::/80"),
+			want:       []string{"2001:db8:1::"},
+			wantIPs:    []string{"2001:db8:1::5/128"},
+		},
+		{
+			name:       "everything excluded still handled",
+			hostRoutes: cidrs("2001:db8:1::5/128"),
+			exclude:    cidrs("2001:db8:1::/64"),
+		},
+		{
+			name:   "static prefix without looking at the routes",
+			static: cidrs("2001:db8:7::/64")[0],
+			want:   []string{"2001:db8:7::"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeRoutes{hostRoutes: tt.hostRoutes, subnets: tt.subnets}
+			if tt.static != nil {
+				// a lookup would fail the tap
+				p.err = errors.New("routes must not be looked up")
+			}
+			cfg := TapConfig{StaticPrefix: tt.static}
+			for _, e := range tt.exclude {
+				cfg.ExcludeSubnets = append(cfg.ExcludeSubnets, *e)
+			}
+			tap := newTestTap(t, cfg, p)
+
+			if got := fmt.Sprint(tap.Prefixes()); got != fmt.Sprint(parseIPs(tt.want)) {
+				t.Errorf("prefixes %s, want %s", got, tt.want)
+			}
+			if got := fmt.Sprint(tap.IPs()); got != fmt.Sprint(cidrs(tt.wantIPs...)) {
+				t.Errorf("host routes %s, want %s", got, tt.wantIPs)
+			}
+			if got := fmt.Sprint(tap.Subnets()); got != fmt.Sprint(cidrs(tt.wantSubnet...)) {
+				t.Errorf("subnets %s, want %s", got, tt.wantSubnet)
+			}
+			if tt.static != nil && atomic.LoadInt32(&p.calls) != 0 {
+				t.Errorf("routes looked up %d times for a static prefix", p.calls)
+			}
+		})
+	}
+}
+
+func TestNewTapWithoutRoutes(t *testing.T) {
+	if _, err := NewTap(loopback(t).Index, WithRouteProvider(&fakeRoutes{})); err == nil {
+		t.Fatal("NewTap succeeded without any routes")
+	}
+	p := &fakeRoutes{err: errors.New("netns gone")}
+	if _, err := NewTap(loopback(t).Index, WithRouteProvider(p)); err == nil {
+		t.Fatal("NewTap succeeded although the route lookup failed")
+	}
+	if p.calls != routeRetries {
+		t.Errorf("routes looked up %d times, want %d", p.calls, routeRetries)
+	}
+}
+
+// parseIPs parses the addresses, nil if there are none like the tap reports no prefixes
+func parseIPs(ips []string) []net.IP {
+	var parsed []net.IP
+	for _, ip := range ips {
+		parsed = append(parsed, net.ParseIP(ip))
+	}
+	return parsed
+}
+
+func TestDeprecatedPrefixExpires(t *testing.T) {
+	tap := newTestTap(t, TapConfig{PrefixValidLifetime: time.Hour, PrefixPreferredLifetime: time.Minute},
+		&fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128", "2001:db8:2::5/128")})
+	// the /128 of 2001:db8:2::/64 moved to another tap
+	tap.routes.update(cidrs("2001:db8:1::5/128"), parseIPs([]string{"2001:db8:1::"}), nil)
+
+	deprecated := func() *AdvertisedPrefix {
+		tap.lock.RLock()
+		defer tap.lock.RUnlock()
+		for _, p := range tap.advertisedPrefixes() {
+			if p.Source == PrefixSourceDeprecated {
+				return &p
+			}
+		}
+		return nil
+	}
+	p := deprecated()
+	if p == nil || !p.Prefix.Equal(net.ParseIP("2001:db8:2::")) {
+		t.Fatalf("deprecated prefix %+v, want 2001:db8:2::", p)
+	}
+	if p.PreferredLifetime != 0 || p.ValidLifetime <= 59*time.Minute || p.ValidLifetime > time.Hour {
+		t.Errorf("lifetimes preferred %v valid %v, want 0 and just under 1h", p.PreferredLifetime, p.ValidLifetime)
+	}
+
+	// half an hour later the host is told it has half an hour left
+	tap.routes.lock.Lock()
+	tap.routes.deprecatedAt["2001:db8:2::"] = time.Now().Add(-30 * time.Minute)
+	tap.routes.lock.Unlock()
+	if p := deprecated(); p == nil || p.ValidLifetime > 30*time.Minute {
+		t.Errorf("deprecated prefix %+v, want its valid lifetime counted down to 30m", p)
+	}
+
+	tap.routes.lock.Lock()
+	tap.routes.deprecatedAt["2001:db8:2::"] = time.Now().Add(-time.Hour)
+	tap.routes.lock.Unlock()
+	if p := deprecated(); p != nil {
+		t.Errorf("deprecated prefix %+v still advertised after its valid lifetime", p)
+	}
+	if _, deprecated, _ := tap.routes.get(); len(deprecated) != 0 {
+		t.Errorf("expired prefixes %s still kept", deprecated)
+	}
+}
@@ -0,0 +1,1379 @@
+package radunnumbered
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	ll "github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// MAX_REACHABLE_TIME from RFC 4861 section 10
+	maxReachableTime = 3600000 * time.Millisecond
+	// default hop limit advertised to hosts
+	DefaultHopLimit = 64
+	// default lifetime of the advertised default route
+	DefaultRouterLifetime = 30 * time.Minute
+	// default prefix lifetimes as recommended by RFC 4861 section 6.2.1
+	DefaultPrefixValidLifetime     = 2592000 * time.Second
+	DefaultPrefixPreferredLifetime = 604800 * time.Second
+	// defaults for the unsolicited RA interval as recommended by RFC 4861 section 6.2.1
+	DefaultMaxInterval = 600 * time.Second
+	DefaultMinInterval = 200 * time.Second
+	// defaults for the initial RA burst, MAX_INITIAL_RTR_ADVERTISEMENTS and MAX_INITIAL_RTR_ADVERT_INTERVAL from
+	// RFC 4861 section 10
+	DefaultInitialAdvertisements = 3
+	DefaultInitialAdvertInterval = 16 * time.Second
+	// bounds of the backoff while waiting for the linklocal dial to succeed
+	dialBackoffMin = 1 * time.Second
+	dialBackoffMax = 30 * time.Second
+	// DefaultDialTimeout gives up on a tap which couldn't be dialed for that long, well above the ~15s the OS may lock it
+	DefaultDialTimeout = 60 * time.Second
+	// reading the routes is retried this many times, waiting routeRetryBackoff doubling with every attempt
+	routeRetries      = 4
+	routeRetryBackoff = 250 * time.Millisecond
+	// joining the all-routers group is retried the same way, it may fail right after the interface got created
+	joinRetries      = 4
+	joinRetryBackoff = 250 * time.Millisecond
+	// the router lifetime is a 16bit field in seconds (RFC 4861 section 4.2), option lifetimes 32bit ones
+	maxRouterLifetime = 65535 * time.Second
+	maxOptionLifetime = ndp.Infinity
+	// maxReconnects limits how often in a row the connection is re-dialed after failing to send
+	maxReconnects = 5
+)
+
+// TapConfig holds the tunables for a Tap, zero values fall back to the defaults
+type TapConfig struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	// InitialAdvertisements are sent at most InitialAdvertInterval apart when the tap comes up, before the regular
+	// min/max interval applies
+	InitialAdvertisements int
+	InitialAdvertInterval time.Duration
+	// MTU overrides the interface MTU advertised in RAs
+	MTU uint32
+	// DNSServers are advertised through the RDNSS option (RFC 8106), one option per distinct lifetime
+	DNSServers []DNSServer
+	// DNSLifetime of the DNS options, 0 uses 3 * MaxInterval as recommended by RFC 8106
+	DNSLifetime time.Duration
+	// SearchDomains are advertised through the DNSSL option (RFC 8106)
+	SearchDomains []string
+	// NAT64Prefix is advertised through the PREF64 option (RFC 8781)
+	NAT64Prefix *net.IPNet
+	// CaptivePortalURI is advertised through the captive portal option (RFC 8910)
+	CaptivePortalURI string
+	// AdvInterval advertises the max interval through the advertisement interval option (RFC 6275) for mobile hosts
+	AdvInterval bool
+	// RouterLifetime of the default route, nil uses the default, 0 means not a default router
+	RouterLifetime *time.Duration
+	// HopLimit hosts should use, nil uses the default of 64, 0 means unspecified
+	HopLimit *uint8
+	// ReachableTime and RetransmitTimer for neighbor discovery on the hosts, 0 means unspecified
+	ReachableTime   time.Duration
+	RetransmitTimer time.Duration
+	// ManagedFlag and OtherFlag set the M and O bits pointing hosts to DHCPv6
+	ManagedFlag bool
+	OtherFlag   bool
+	// AutoOtherFlag sets the O bit if neither dns servers nor search domains are advertised, so hosts get them
+	// via DHCPv6. it never clears an OtherFlag set explicitly
+	AutoOtherFlag bool
+	// Preference is the default router preference (RFC 4191): high, medium or low
+	Preference string
+	// Priority replaces Preference if set: above 0 is high, 0 medium and below 0 low
+	Priority *int
+	// AdvertiseSubnetRoutes emits a Route Information option (RFC 4191) for every subnet route
+	AdvertiseSubnetRoutes bool
+	// RoutePreference applies to these route information options only, Preference to the default route
+	RoutePreference string
+	RouteLifetime   time.Duration
+	// DefaultRouteLifetime adds a route information option for ::/0, independent of the router lifetime in the RA header.
+	// i.e. a low preference backup default route. 0 sends none
+	DefaultRouteLifetime   time.Duration
+	DefaultRoutePreference string
+	// PrefixFlags overrides the on-link/autonomous flags per advertised /64, keyed by the prefix (2001:db8::/64)
+	PrefixFlags map[string]PrefixFlags
+	// ULAOnLinkOnly advertises ULA prefixes (fc00::/7) on-link without the autonomous flag, instead of like GUAs
+	ULAOnLinkOnly bool
+	// HostRouteOnLink sets the on-link flag of prefixes only derived from host routes as well. prefixes covered by
+	// a subnet route to the tap are always on-link, explicit PrefixFlags still apply
+	HostRouteOnLink bool
+	// SuppressAnycastOnLink clears the on-link flag of prefixes whose subnet-router anycast address is assigned to the tap
+	SuppressAnycastOnLink bool
+	// PrefixValidLifetime and PrefixPreferredLifetime apply to every prefix information option
+	PrefixValidLifetime     time.Duration
+	PrefixPreferredLifetime time.Duration
+	// Mode is one of full-router (default), addressing-only or onlink-only. the latter two advertise a router lifetime of 0
+	// and replace the default prefix flags, explicit PrefixFlags still apply
+	Mode string
+	// IncludeSLLA sends the source link-layer address option, nil uses the default of true
+	IncludeSLLA *bool
+	// RespondToRS answers router solicitations, nil uses the default of true. false only sends unsolicited RAs
+	RespondToRS *bool
+	// ForceMulticastResponse answers solicits via all-nodes multicast, even if they could be answered unicast.
+	// some guest stacks don't process unicast RAs
+	ForceMulticastResponse bool
+	// MaxRSPerSource limits the solicits answered per source address and minute, so a single host can't make us
+	// flood the segment with RAs. 0 is unlimited
+	MaxRSPerSource int
+	// AcceptICMP are additional ICMPv6 types let through the filter, by name (see icmpTypes). router solicitations
+	// are always accepted as long as they are answered
+	AcceptICMP []string
+	// SourceLinkLocal is the link-local address to send from, it has to be configured on the interface.
+	// nil picks one automatically
+	SourceLinkLocal net.IP
+	// UnicastPeriodicTo sends even the unsolicited RAs unicast to this link-local address, i.e. the single host
+	// of a point-to-point tap on a large segment. nil sends them to all nodes
+	UnicastPeriodicTo net.IP
+	// RespondToNS answers neighbor solicitations for our link-local and held subnet-router anycast addresses,
+	// for stacks revalidating the router's reachability the kernel doesn't answer
+	RespondToNS bool
+	// StaticPrefix is advertised instead of the /64s derived from the host routes, which are not looked at then
+	StaticPrefix *net.IPNet
+	// ExcludeSubnets are never advertised, routes within them are ignored
+	ExcludeSubnets []net.IPNet
+	// DryRun logs the RAs instead of sending them, the all-routers group isn't joined either
+	DryRun bool
+	// LogRAContents logs a summary of every RA sent at debug level
+	LogRAContents bool
+	// Observe only logs the solicits received on the tap, no RA is ever sent
+	Observe bool
+	// WaitForHost sends RAs without prefixes until a host on the tap sent a router or neighbor solicitation, so
+	// prefixes of VMs not running yet aren't announced
+	WaitForHost bool
+	// DialTimeout gives up on a tap which can't be dialed for that long, 0 uses DefaultDialTimeout
+	DialTimeout time.Duration
+	// RouteProvider looks up the routes of the tap, nil reads them via netlink
+	RouteProvider RouteProvider
+	// Interfaces holds per interface name overrides, merged over the settings above by NewTap
+	Interfaces map[string]InterfaceConfig
+}
+
+// modes of a tap, setting router lifetime and prefix flags in one go
+const (
+	// ModeFullRouter is a default router, prefixes as configured
+	ModeFullRouter = "full-router"
+	// ModeAddressingOnly hands out addresses (on-link and autonomous) while some other device is the default router
+	ModeAddressingOnly = "addressing-only"
+	// ModeOnLinkOnly only tells hosts the prefixes are on-link, no addresses and no default router
+	ModeOnLinkOnly = "onlink-only"
+)
+
+// PrefixFlags are the L and A bits of a prefix information option
+type PrefixFlags struct {
+	OnLink     bool
+	Autonomous bool
+	// RouterAddress sets the R bit, carrying this full address within the prefix instead of only the prefix.
+	// used by Mobile IPv6 home agents (RFC 6275 section 7.2), nil leaves it off
+	RouterAddress net.IP
+}
+
+// AdvertisedPrefix is a prefix as it goes out in a prefix information option
+type AdvertisedPrefix struct {
+	Prefix     net.IP
+	Length     uint8
+	OnLink     bool
+	Autonomous bool
+	// RouterAddress is the full address carried instead of the prefix if the R bit is set, nil otherwise
+	RouterAddress     net.IP
+	ValidLifetime     time.Duration
+	PreferredLifetime time.Duration
+	Source            PrefixSource
+}
+
+// PrefixSource tells where an advertised prefix came from
+type PrefixSource string
+
+const (
+	// PrefixSourceHostRoute is derived from a host route to the tap
+	PrefixSourceHostRoute PrefixSource = "host-route"
+	// PrefixSourceSubnet is covered by a subnet route to the tap
+	PrefixSourceSubnet PrefixSource = "subnet"
+	// PrefixSourceStatic is the configured static prefix
+	PrefixSourceStatic PrefixSource = "static"
+	// PrefixSourceDeprecated lost its route and is only advertised until hosts stopped using it
+	PrefixSourceDeprecated PrefixSource = "deprecated"
+)
+
+// flags returns the PrefixFlags of the advertised prefix
+func (p AdvertisedPrefix) flags() PrefixFlags {
+	return PrefixFlags{OnLink: p.OnLink, Autonomous: p.Autonomous, RouterAddress: p.RouterAddress}
+}
+
+// defaultPrefixFlags are used for prefixes without override. unnumbered hosts only have a /128 route,
+// so the prefix is not on-link but used for SLAAC
+var defaultPrefixFlags = PrefixFlags{OnLink: false, Autonomous: true}
+
+// Tap is the interface object
+type Tap struct {
+	// lastRA is the time (unix nano) of the last RA sent, or of creation, accessed atomically.
+	// first in the struct to keep it 64bit aligned
+	lastRA int64
+	// rsCount counts the solicits received, accessed atomically
+	rsCount int64
+	// lock guards the tunables below against a config reload while the tap is running
+	lock sync.RWMutex
+	c    ndpConn
+	Ifi  *net.Interface
+	// addr is the linklocal address we are sending from, set once listening
+	addr  net.IP
+	ctx   context.Context
+	Close context.CancelFunc
+	// routes holds the prefixes and subnets, they change with the routing table while the tap is running
+	routes        *routeState
+	routeProvider RouteProvider
+	MinInterval   time.Duration
+	MaxInterval   time.Duration
+	// InitialAdvertisements and InitialAdvertInterval shape the burst of RAs after dialing
+	InitialAdvertisements int
+	InitialAdvertInterval time.Duration
+	// MTU advertised in the MTU option, 0 means no MTU option is sent
+	MTU           uint32
+	DNSServers    []DNSServer
+	DNSLifetime   time.Duration
+	SearchDomains []string
+	NAT64Prefix   *net.IPNet
+	// CaptivePortalURI advertised to hosts, empty means no captive portal option is sent
+	CaptivePortalURI string
+	AdvInterval      bool
+	// RouterLifetime advertised in the RA header, 0 tells hosts to not use us as default router
+	RouterLifetime time.Duration
+	// HopLimit advertised as current hop limit, 0 leaves it unspecified
+	HopLimit uint8
+	// ReachableTime and RetransmitTimer advertised for neighbor discovery, 0 leaves them unspecified
+	ReachableTime   time.Duration
+	RetransmitTimer time.Duration
+	ManagedFlag     bool
+	OtherFlag       bool
+	AutoOtherFlag   bool
+	Preference      ndp.Preference
+	// Priority the Preference got derived from, nil if it was given by name
+	Priority *int
+	// AdvertiseSubnetRoutes enables Route Information options for the subnet routes
+	AdvertiseSubnetRoutes bool
+	RoutePreference       ndp.Preference
+	RouteLifetime         time.Duration
+	// DefaultRouteLifetime of the ::/0 route information option, 0 means none is sent
+	DefaultRouteLifetime   time.Duration
+	DefaultRoutePreference ndp.Preference
+	PrefixFlags            map[string]PrefixFlags
+	ULAOnLinkOnly          bool
+	HostRouteOnLink        bool
+	SuppressAnycastOnLink  bool
+	// PrefixValidLifetime and PrefixPreferredLifetime of the advertised prefixes
+	PrefixValidLifetime     time.Duration
+	PrefixPreferredLifetime time.Duration
+	// Mode overrides router lifetime and default prefix flags unless it is full-router
+	Mode string
+	// IncludeSLLA adds the source link-layer address option with the tap's MAC to every RA
+	IncludeSLLA bool
+	// RespondToRS joins the all-routers group and answers solicits, changes apply once the tap re-dials
+	RespondToRS bool
+	// ForceMulticastResponse sends solicited RAs to all nodes even when they could go unicast
+	ForceMulticastResponse bool
+	MaxRSPerSource         int
+	// AcceptICMP are let through the ICMP filter on top of the solicits, changes apply once the tap re-dials
+	AcceptICMP []ipv6.ICMPType
+	// competitors are the other routers seen advertising on the tap by source, with what they advertised last.
+	// only accessed by the receive loop
+	competitors map[string]string
+	// SourceLinkLocal is the address to dial, nil picks one. changes apply once the tap re-dials
+	SourceLinkLocal net.IP
+	// UnicastPeriodicTo sends the periodic RAs to this link-local address instead of all nodes, nil multicasts them
+	UnicastPeriodicTo net.IP
+	// RespondToNS answers neighbor solicitations for our addresses, changes apply once the tap re-dials
+	RespondToNS bool
+	// WaitForHost holds back the prefixes until hostSeen, changes to the filter apply once the tap re-dials
+	WaitForHost bool
+	// hostSeen is set by the first solicit of a host on the tap, guarded by lock
+	hostSeen bool
+	// StaticPrefix pins the advertised prefix, nil means prefixes are detected from the routes
+	StaticPrefix *net.IPNet
+	// draining deprecates all prefixes (preferred lifetime 0) ahead of maintenance, guarded by lock
+	draining bool
+	// retired are prefixes deprecated on request while still advertised, i.e. when renumbering. guarded by lock
+	retired []net.IP
+	// linkDown pauses advertising until the link comes back, guarded by lock
+	linkDown bool
+	// linkChange is signaled whenever linkDown changes
+	linkChange chan struct{}
+	// ExcludeSubnets are ignored when reading the routes
+	ExcludeSubnets []net.IPNet
+	DryRun         bool
+	LogRAContents  bool
+	// Observe logs solicits instead of answering them and sends no RAs, changes apply once the tap re-dials
+	Observe     bool
+	DialTimeout time.Duration
+	// onListening is called after every successful dial, if set
+	onListening func()
+	// generation tells taps on a reused ifindex apart, set by the engine when added
+	generation uint64
+	// hwAddr is the MAC of the interface itself, re-read on every dial. 802.1Q subinterfaces inherit the one of
+	// their parent unless set explicitly and follow its changes. guarded by lock
+	hwAddr net.HardwareAddr
+	// rs triggers an RA, sent unicast to the address if not nil, otherwise to all nodes
+	rs chan net.IP
+}
+
+// TapOption customizes the config of a tap created by NewTap
+type TapOption func(*TapConfig)
+
+// WithConfig replaces the whole config, options given after it apply on top
+func WithConfig(cfg TapConfig) TapOption {
+	return func(c *TapConfig) {
+		*c = cfg
+	}
+}
+
+// WithMTU advertises mtu instead of the interface MTU
+func WithMTU(mtu uint32) TapOption {
+	return func(c *TapConfig) {
+		c.MTU = mtu
+	}
+}
+
+// WithDNS advertises the dns servers via RDNSS
+func WithDNS(servers ...DNSServer) TapOption {
+	return func(c *TapConfig) {
+		c.DNSServers = servers
+	}
+}
+
+// WithRDNSSLifetime sets the lifetime of the dns servers not having their own
+func WithRDNSSLifetime(l time.Duration) TapOption {
+	return func(c *TapConfig) {
+		c.DNSLifetime = l
+	}
+}
+
+// WithRouterLifetime sets the lifetime of the default route, 0 means not a default router
+func WithRouterLifetime(l time.Duration) TapOption {
+	return func(c *TapConfig) {
+		c.RouterLifetime = &l
+	}
+}
+
+// WithPreference sets the default router preference: high, medium or low
+func WithPreference(p string) TapOption {
+	return func(c *TapConfig) {
+		c.Preference = p
+	}
+}
+
+// WithRouteProvider looks up the routes of the tap via p instead of netlink
+func WithRouteProvider(p RouteProvider) TapOption {
+	return func(c *TapConfig) {
+		c.RouteProvider = p
+	}
+}
+
+// NewTap finds, verifies and gets all aparms for a new Tap and returns the object.
+// without options the defaults apply
+func NewTap(idx int, opts ...TapOption) (*Tap, error) {
+	var cfg TapConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ifi, err := net.InterfaceByIndex(idx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get interface: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t := &Tap{
+		ctx:           ctx,
+		Close:         cancel,
+		Ifi:           ifi,
+		routes:        &routeState{},
+		routeProvider: cfg.RouteProvider,
+		rs:            make(chan net.IP, 1),
+		linkChange:    make(chan struct{}, 1),
+		competitors:   map[string]string{},
+		lastRA:        time.Now().UnixNano(),
+		hwAddr:        ifi.HardwareAddr,
+		// dialing waits for the link in case it isn't up yet
+		linkDown: ifi.Flags&net.FlagUp == 0 || !linkUpByIndex(ifi.Index),
+	}
+	if t.routeProvider == nil {
+		t.routeProvider = NetlinkRoutes{}
+	}
+	if err := t.apply(cfg.forInterface(ifi.Name)); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if !usableHardwareAddr(ifi.HardwareAddr) {
+		ll.WithFields(ll.Fields{"Interface": ifi.Name}).
+			Warnf("%s has no usable hardware address (%q), not sending the source link-layer address option", ifi.Name, ifi.HardwareAddr)
+	}
+
+	// a static prefix doesn't depend on the routes, so it can be advertised before they show up
+	if t.StaticPrefix != nil {
+		ll.WithFields(ll.Fields{"Interface": ifi.Name}).
+			Infof("%s using static prefix %s, not detecting routes", ifi.Name, t.StaticPrefix)
+		t.routes.update(nil, []net.IP{t.StaticPrefix.IP}, nil)
+		t.checkSubnetAnycast()
+		return t, nil
+	}
+
+	hostRoutes, subnets, err := t.readRoutes()
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed getting routes for if %v: %v", ifi.Name, err)
+	}
+
+	if hostRoutes == nil && subnets == nil {
+		cancel()
+		return nil, fmt.Errorf(
+			"neither host nor subnet routes to this tap. this may be a private vlan interface, ignoring comletely",
+		)
+	}
+
+	// a tap whose routes are all excluded is still handled, just without prefixes
+	hostRoutes, prefixesChosen, subnets := t.selectRoutes(hostRoutes, subnets, t.ExcludeSubnets)
+	ll.WithFields(ll.Fields{"Interface": ifi.Name}).
+		Debugf("%d host routes found on %v in /64s: %v", len(hostRoutes), ifi.Name, prefixesChosen)
+	ll.WithFields(ll.Fields{"Interface": ifi.Name}).Tracef("host routes found on %v: %v", ifi.Name, hostRoutes)
+	ll.WithFields(ll.Fields{"Interface": ifi.Name}).Debugf("subnet routes found on %v: %v", ifi.Name, subnets)
+
+	if prefixesChosen == nil {
+		ll.WithFields(ll.Fields{"Interface": ifi.Name}).
+			Warnf("%s has no usable host routes, only advertising RA without prefix for SLAAC", ifi.Name)
+	} else {
+		ll.WithFields(ll.Fields{"Interface": ifi.Name}).
+			Infof("%s using detected prefixes %s", ifi.Name, prefixesChosen)
+	}
+	t.routes.update(hostRoutes, prefixesChosen, subnets)
+	t.checkSubnetAnycast()
+	return t, nil
+}
+
+// selectRoutes collapses the host routes into distinct /64s, so the logs stay readable, and drops everything
+// overlapping exclude. it warns if there were prefixes but all got excluded
+func (t *Tap) selectRoutes(hostRoutes, subnets []*net.IPNet, exclude []net.IPNet) ([]*net.IPNet, []net.IP, []*net.IPNet) {
+	candidates := prefixesFromHostRoutes(t.Ifi.Name, hostRoutes)
+	prefixes := excludePrefixes(t.Ifi.Name, candidates, exclude)
+	if len(candidates) > 0 && len(prefixes) == 0 {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("all prefixes of %s are excluded, only advertising RA without prefix for SLAAC", t.Ifi.Name)
+	}
+	return excludeRoutes(hostRoutes, exclude), prefixes, excludeRoutes(subnets, exclude)
+}
+
+// readRoutes gets the routes of the tap, retrying with backoff if the netlink query fails.
+// no routes at all is a valid result and not retried
+func (t *Tap) readRoutes() ([]*net.IPNet, []*net.IPNet, error) {
+	backoff := routeRetryBackoff
+	for attempt := 1; ; attempt++ {
+		hostRoutes, subnets, err := t.routeProvider.Routes(t.Ifi.Index)
+		if err == nil || attempt >= routeRetries {
+			return hostRoutes, subnets, err
+		}
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("%v, retrying in %v... %d", err, backoff, attempt)
+		select {
+		case <-t.ctx.Done():
+			return nil, nil, context.Canceled
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Healthy reports if the tap sent an RA within twice its max interval. paused taps are not to blame, so they are healthy
+func (t *Tap) Healthy() bool {
+	t.lock.RLock()
+	maxInterval := t.MaxInterval
+	down, observe := t.linkDown, t.Observe
+	t.lock.RUnlock()
+	// observing taps don't send any RAs
+	if down || observe {
+		return true
+	}
+	last := time.Unix(0, atomic.LoadInt64(&t.lastRA))
+	return time.Since(last) <= 2*maxInterval
+}
+
+// SetLinkUp pauses advertising while the link is down and resumes once it is up again
+func (t *Tap) SetLinkUp(up bool) {
+	t.lock.Lock()
+	changed := t.linkDown == up
+	t.linkDown = !up
+	t.lock.Unlock()
+	if !changed {
+		return
+	}
+	if up {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s link up, resuming", t.Ifi.Name)
+	} else {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s link down, pausing", t.Ifi.Name)
+	}
+	select {
+	case t.linkChange <- struct{}{}:
+	default:
+	}
+}
+
+// SetDraining deprecates all prefixes while set, so existing connections survive but new ones use other addresses.
+// RAs keep going out, solicits are still answered. an RA is sent right away on change
+func (t *Tap) SetDraining(drain bool) {
+	t.lock.Lock()
+	changed := t.draining != drain
+	t.draining = drain
+	t.lock.Unlock()
+	if !changed {
+		return
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s draining: %v", t.Ifi.Name, drain)
+	t.trigger(nil)
+}
+
+// SetDNSServers replaces the advertised dns servers at runtime and returns the previous ones, an empty list drops
+// the RDNSS option. an RA is sent right away. a config reload sets them back to the configured ones
+func (t *Tap) SetDNSServers(servers []DNSServer) ([]DNSServer, error) {
+	servers, err := t.dnsServers(servers)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	prev := t.DNSServers
+	t.DNSServers = servers
+	t.lock.Unlock()
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s dns servers changed from %v to %v", t.Ifi.Name, prev, servers)
+	t.trigger(nil)
+	return prev, nil
+}
+
+// SetPrefixDeprecated deprecates (preferred lifetime 0) a single /64 while it is still advertised, so hosts move to
+// the other prefixes without losing the connections using it. an RA is sent right away on change
+func (t *Tap) SetPrefixDeprecated(prefix *net.IPNet, deprecated bool) error {
+	if l, _ := prefix.Mask.Size(); l != 64 || prefix.IP.To4() != nil {
+		return fmt.Errorf("prefix %s must be an ipv6 /64", prefix)
+	}
+	t.lock.Lock()
+	changed := containsIP(t.retired, prefix.IP) != deprecated
+	if changed && deprecated {
+		t.retired = append(t.retired, prefix.IP)
+	} else if changed {
+		var retired []net.IP
+		for _, p := range t.retired {
+			if !p.Equal(prefix.IP) {
+				retired = append(retired, p)
+			}
+		}
+		t.retired = retired
+	}
+	t.lock.Unlock()
+	if !changed {
+		return nil
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s prefix %s deprecated: %v", t.Ifi.Name, prefix, deprecated)
+	t.trigger(nil)
+	return nil
+}
+
+// Draining reports if the tap is draining
+func (t *Tap) Draining() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.draining
+}
+
+// LinkUp reports if the link is up, as far as the tap knows
+func (t *Tap) LinkUp() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return !t.linkDown
+}
+
+// waitLinkUp blocks while the link is down, or until the tap gets closed
+func (t *Tap) waitLinkUp() error {
+	for !t.LinkUp() {
+		select {
+		case <-t.ctx.Done():
+			return context.Canceled
+		case <-t.linkChange:
+		}
+	}
+	return nil
+}
+
+// Reconfigure applies a new config to a running tap, the next RA will reflect it
+func (t *Tap) Reconfigure(cfg TapConfig) error {
+	t.lock.Lock()
+	static, exclude := t.StaticPrefix, fmt.Sprint(t.ExcludeSubnets)
+	if err := t.apply(cfg.forInterface(t.Ifi.Name)); err != nil {
+		t.lock.Unlock()
+		return err
+	}
+	newStatic, newExclude := t.StaticPrefix, t.ExcludeSubnets
+	t.lock.Unlock()
+	// the prefixes have to follow if the static prefix got set, changed or removed, or other subnets are excluded.
+	// the routes are looked up without holding the lock, netlink may take a while
+	if static.String() != newStatic.String() || exclude != fmt.Sprint(newExclude) {
+		if err := t.updateRoutes(newStatic, newExclude); err != nil {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Warnf("failed refreshing routes: %v", err)
+		}
+	}
+	// kick off an RA so hosts learn about the change right away
+	t.trigger(nil)
+	return nil
+}
+
+// apply verifies cfg and sets the resulting tunables on the tap, unset values fall back to the defaults
+func (t *Tap) apply(cfg TapConfig) error {
+	maxInterval := cfg.MaxInterval
+	if maxInterval == 0 {
+		maxInterval = DefaultMaxInterval
+	}
+	minInterval := cfg.MinInterval
+	if minInterval == 0 {
+		minInterval = DefaultMinInterval
+		// RFC 4861 default for MinRtrAdvInterval is 0.33 * MaxRtrAdvInterval
+		if cfg.MaxInterval != 0 {
+			minInterval = maxInterval / 3
+		}
+	}
+	if minInterval < 3*time.Second || maxInterval < 4*time.Second {
+		return fmt.Errorf("RA interval %v-%v too short, must be at least 3s-4s", minInterval, maxInterval)
+	}
+	if minInterval > maxInterval*3/4 {
+		return fmt.Errorf("min RA interval %v must not exceed 0.75 * max interval %v", minInterval, maxInterval)
+	}
+	initialAdvertisements := cfg.InitialAdvertisements
+	if initialAdvertisements == 0 {
+		initialAdvertisements = DefaultInitialAdvertisements
+	}
+	if initialAdvertisements < 0 {
+		return fmt.Errorf("initial advertisements %d must not be negative", initialAdvertisements)
+	}
+	initialInterval := cfg.InitialAdvertInterval
+	if initialInterval == 0 {
+		initialInterval = DefaultInitialAdvertInterval
+		// the default only caps, it must not get in the way of a short max interval
+		if initialInterval > maxInterval {
+			initialInterval = maxInterval
+		}
+	}
+	if initialInterval < 0 || initialInterval > maxInterval {
+		return fmt.Errorf("initial RA interval %v out of range, must be 0-%v (max interval)", initialInterval, maxInterval)
+	}
+
+	mtu := uint32(t.Ifi.MTU)
+	if cfg.MTU != 0 {
+		mtu = cfg.MTU
+	}
+
+	dnsServers, err := t.dnsServers(cfg.DNSServers)
+	if err != nil {
+		return err
+	}
+
+	if cfg.DNSLifetime < 0 {
+		return fmt.Errorf("dns lifetime %v must not be negative", cfg.DNSLifetime)
+	}
+	dnsLifetime := t.clampLifetime("dns lifetime", cfg.DNSLifetime, maxOptionLifetime)
+
+	var domains []string
+	for _, d := range cfg.SearchDomains {
+		d = strings.TrimSuffix(d, ".")
+		if !validDomain(d) {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Warnf("skipping invalid search domain %q", d)
+			continue
+		}
+		domains = append(domains, d)
+	}
+
+	if cfg.NAT64Prefix != nil {
+		if err := checkPREF64(cfg.NAT64Prefix); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AdvInterval {
+		if err := checkAdvInterval(maxInterval); err != nil {
+			return err
+		}
+	}
+
+	if cfg.CaptivePortalURI != "" {
+		if err := checkCaptivePortal(cfg.CaptivePortalURI); err != nil {
+			return err
+		}
+	}
+
+	if cfg.StaticPrefix != nil {
+		if l, _ := cfg.StaticPrefix.Mask.Size(); l != 64 {
+			return fmt.Errorf("static prefix %s must be a /64", cfg.StaticPrefix)
+		}
+		if err := checkPrefix(cfg.StaticPrefix.IP); err != nil {
+			return fmt.Errorf("invalid static prefix: %v", err)
+		}
+	}
+
+	var acceptICMP []ipv6.ICMPType
+	for _, name := range cfg.AcceptICMP {
+		typ, ok := icmpTypes[name]
+		if !ok {
+			return fmt.Errorf("unknown ICMP type %q, must be one of %s", name, strings.Join(icmpTypeNames(), ", "))
+		}
+		acceptICMP = append(acceptICMP, typ)
+	}
+
+	if cfg.SourceLinkLocal != nil {
+		if !cfg.SourceLinkLocal.IsLinkLocalUnicast() {
+			return fmt.Errorf("source address %s is not a link-local unicast address", cfg.SourceLinkLocal)
+		}
+		// Validate has no link to look at, NewTap and Reconfigure check again on the real one
+		if t.Ifi.Index != 0 && !containsIP(linkLocalAddrs(t.Ifi), cfg.SourceLinkLocal) {
+			return fmt.Errorf("source address %s is not configured on %s", cfg.SourceLinkLocal, t.Ifi.Name)
+		}
+	}
+	if cfg.UnicastPeriodicTo != nil && !cfg.UnicastPeriodicTo.IsLinkLocalUnicast() {
+		return fmt.Errorf("periodic RA destination %s is not a link-local unicast address", cfg.UnicastPeriodicTo)
+	}
+
+	routerLifetime := DefaultRouterLifetime
+	if cfg.RouterLifetime != nil {
+		routerLifetime = *cfg.RouterLifetime
+	}
+	if routerLifetime < 0 {
+		return fmt.Errorf("router lifetime %v must not be negative", routerLifetime)
+	}
+	routerLifetime = t.clampLifetime("router lifetime", routerLifetime, maxRouterLifetime)
+
+	hopLimit := uint8(DefaultHopLimit)
+	if cfg.HopLimit != nil {
+		hopLimit = *cfg.HopLimit
+	}
+
+	if cfg.ReachableTime < 0 || cfg.ReachableTime > maxReachableTime {
+		return fmt.Errorf("reachable time %v out of range, must be 0-%v", cfg.ReachableTime, maxReachableTime)
+	}
+	// the retransmit timer is a 32bit field in milliseconds
+	if cfg.RetransmitTimer < 0 || cfg.RetransmitTimer/time.Millisecond > math.MaxUint32 {
+		return fmt.Errorf("retransmit timer %v out of range", cfg.RetransmitTimer)
+	}
+
+	prf, err := parsePreference(cfg.Preference)
+	if err != nil {
+		return err
+	}
+	if cfg.Priority != nil {
+		prf = priorityPreference(*cfg.Priority)
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Debugf("%s priority %d maps to router preference %s", t.Ifi.Name, *cfg.Priority, prf)
+	}
+
+	routePrf, err := parsePreference(cfg.RoutePreference)
+	if err != nil {
+		return err
+	}
+	routeLifetime := cfg.RouteLifetime
+	if routeLifetime == 0 {
+		routeLifetime = DefaultRouterLifetime
+	}
+	routeLifetime = t.clampLifetime("route lifetime", routeLifetime, maxOptionLifetime)
+	defaultRoutePrf, err := parsePreference(cfg.DefaultRoutePreference)
+	if err != nil {
+		return err
+	}
+	if cfg.DefaultRouteLifetime < 0 {
+		return fmt.Errorf("default route lifetime %v must not be negative", cfg.DefaultRouteLifetime)
+	}
+	defaultRouteLifetime := t.clampLifetime("default route lifetime", cfg.DefaultRouteLifetime, maxOptionLifetime)
+
+	for p, f := range cfg.PrefixFlags {
+		if f.RouterAddress == nil {
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("invalid prefix %s: %v", p, err)
+		}
+		if !n.Contains(f.RouterAddress) {
+			return fmt.Errorf("router address %s is not within prefix %s", f.RouterAddress, p)
+		}
+	}
+
+	mode := cfg.Mode
+	switch mode {
+	case "":
+		mode = ModeFullRouter
+	case ModeFullRouter, ModeAddressingOnly, ModeOnLinkOnly:
+	default:
+		return fmt.Errorf(
+			"invalid mode %q, must be one of %s, %s or %s",
+			cfg.Mode,
+			ModeFullRouter,
+			ModeAddressingOnly,
+			ModeOnLinkOnly,
+		)
+	}
+
+	if cfg.MaxRSPerSource < 0 {
+		return fmt.Errorf("max solicits per source %d must not be negative", cfg.MaxRSPerSource)
+	}
+
+	dialTimeout := cfg.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = DefaultDialTimeout
+	}
+	if dialTimeout < 0 {
+		return fmt.Errorf("dial timeout %v must not be negative", dialTimeout)
+	}
+
+	validLifetime := cfg.PrefixValidLifetime
+	if validLifetime == 0 {
+		validLifetime = DefaultPrefixValidLifetime
+	}
+	preferredLifetime := cfg.PrefixPreferredLifetime
+	if preferredLifetime == 0 {
+		preferredLifetime = DefaultPrefixPreferredLifetime
+	}
+	validLifetime = t.clampLifetime("prefix valid lifetime", validLifetime, maxOptionLifetime)
+	preferredLifetime = t.clampLifetime("prefix preferred lifetime", preferredLifetime, maxOptionLifetime)
+	if preferredLifetime > validLifetime {
+		return fmt.Errorf(
+			"prefix preferred lifetime %v must not exceed valid lifetime %v",
+			preferredLifetime,
+			validLifetime,
+		)
+	}
+
+	t.MinInterval = minInterval
+	t.MaxInterval = maxInterval
+	t.InitialAdvertisements = initialAdvertisements
+	t.InitialAdvertInterval = initialInterval
+	t.MTU = mtu
+	t.DNSServers = dnsServers
+	t.DNSLifetime = dnsLifetime
+	t.SearchDomains = domains
+	t.NAT64Prefix = cfg.NAT64Prefix
+	t.CaptivePortalURI = cfg.CaptivePortalURI
+	t.AdvInterval = cfg.AdvInterval
+	t.RouterLifetime = routerLifetime
+	t.HopLimit = hopLimit
+	t.ReachableTime = cfg.ReachableTime
+	t.RetransmitTimer = cfg.RetransmitTimer
+	t.ManagedFlag = cfg.ManagedFlag
+	t.OtherFlag = cfg.OtherFlag
+	t.AutoOtherFlag = cfg.AutoOtherFlag
+	t.Preference = prf
+	t.Priority = cfg.Priority
+	t.AdvertiseSubnetRoutes = cfg.AdvertiseSubnetRoutes
+	t.RoutePreference = routePrf
+	t.RouteLifetime = routeLifetime
+	t.DefaultRouteLifetime = defaultRouteLifetime
+	t.DefaultRoutePreference = defaultRoutePrf
+	t.PrefixFlags = cfg.PrefixFlags
+	t.ULAOnLinkOnly = cfg.ULAOnLinkOnly
+	t.HostRouteOnLink = cfg.HostRouteOnLink
+	t.SuppressAnycastOnLink = cfg.SuppressAnycastOnLink
+	t.PrefixValidLifetime = validLifetime
+	t.PrefixPreferredLifetime = preferredLifetime
+	t.StaticPrefix = cfg.StaticPrefix
+	t.Mode = mode
+	t.ExcludeSubnets = cfg.ExcludeSubnets
+	t.DryRun = cfg.DryRun
+	t.LogRAContents = cfg.LogRAContents
+	t.Observe = cfg.Observe
+	t.WaitForHost = cfg.WaitForHost
+	t.DialTimeout = dialTimeout
+	t.IncludeSLLA = cfg.IncludeSLLA == nil || *cfg.IncludeSLLA
+	t.RespondToRS = cfg.RespondToRS == nil || *cfg.RespondToRS
+	t.ForceMulticastResponse = cfg.ForceMulticastResponse
+	t.MaxRSPerSource = cfg.MaxRSPerSource
+	t.RespondToNS = cfg.RespondToNS
+	t.SourceLinkLocal = cfg.SourceLinkLocal
+	t.UnicastPeriodicTo = cfg.UnicastPeriodicTo
+	t.AcceptICMP = acceptICMP
+	return nil
+}
+
+// Listen starts listening for RouterSolicits on this tap and sends periodic RAs.
+// if sending fails transiently (i.e. the interface flapped) the connection is re-dialed up to maxReconnects times in a row
+func (t *Tap) Listen() error {
+	reconnects := 0
+	for {
+		c, err := t.dial()
+		if err != nil {
+			return err
+		}
+		last := atomic.LoadInt64(&t.lastRA)
+		err = t.doRA(c)
+		c.Close()
+		if t.ctx.Err() != nil {
+			return err
+		}
+		// the link went away for now, dial waits for it to come back
+		if errors.Is(err, errLinkDown) {
+			continue
+		}
+		if !transientSendError(err) {
+			return err
+		}
+
+		// the counter only covers connections failing right away, one which managed to send is fine
+		if atomic.LoadInt64(&t.lastRA) != last {
+			reconnects = 0
+		}
+		if reconnects++; reconnects > maxReconnects {
+			return fmt.Errorf("giving up after %d reconnects: %w", maxReconnects, err)
+		}
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("%s: %v, reconnecting... %d", t.Ifi.Name, err, reconnects)
+		select {
+		case <-t.ctx.Done():
+			return context.Canceled
+		case <-time.After(dialBackoffMin):
+		}
+	}
+}
+
+// dial opens the linklocal ndp connection of the tap, retrying until it succeeds or the tap gets closed
+func (t *Tap) dial() (ndpConn, error) {
+	var c ndpConn
+	var ip net.IP
+	var err error
+
+	t.lock.RLock()
+	timeout := t.DialTimeout
+	// ndp takes a literal address as well
+	addr := ndp.LinkLocal
+	if t.SourceLinkLocal != nil {
+		addr = ndp.Addr(t.SourceLinkLocal.String())
+	}
+	t.lock.RUnlock()
+
+	// need this hacky loop since there are occasions where the OS seems to lock the tap for about 15sec (or sometimes longer)
+	// on innitial creation. causing the dialer to fail.
+	// this loop watches the context for cancellation but otherwise re-tries until timeout passed since the first failure
+	counter := 0
+	backoff := dialBackoffMin
+	var failingSince time.Time
+	defer func() {
+		if counter > 0 {
+			metricTapsDialing.Dec()
+		}
+	}()
+	for {
+		if err := t.waitLinkUp(); err != nil {
+			return nil, err
+		}
+		c, ip, err = ndpListen(t.Ifi, addr)
+		// RAs have to come from a link-local address (RFC 4861 section 4.2), without one this is just another failure
+		if err == nil && !ip.IsLinkLocalUnicast() {
+			c.Close()
+			err = fmt.Errorf("no usable link-local address, got %q", ip)
+		}
+		if err != nil {
+			if counter == 0 {
+				failingSince = time.Now()
+				metricTapsDialing.Inc()
+			}
+			counter++
+			if time.Since(failingSince) >= timeout {
+				return nil, fmt.Errorf("%w: unable to dial linklocal for %v: %v", errDialTimeout, timeout, err)
+			}
+			// backing off exponentially with jitter so many taps created at once don't retry in lockstep
+			wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+				Warnf("unable to dial linklocal: %s, retrying in %v... %d", err, wait.Round(time.Millisecond), counter)
+			// waiting for the next attempt, but bailing out right away if the context gets canceled meanwhile
+			select {
+			case <-t.ctx.Done():
+				return nil, context.Canceled
+			case <-time.After(wait):
+			}
+			if backoff *= 2; backoff > dialBackoffMax {
+				backoff = dialBackoffMax
+			}
+		} else {
+			ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("successfully dialed linklocal: %v", t.Ifi.Name)
+			break
+		}
+	}
+
+	t.lock.RLock()
+	respond, dryRun, observe, respondNS := t.RespondToRS, t.DryRun, t.Observe, t.RespondToNS
+	accept, waitForHost := t.AcceptICMP, t.WaitForHost && !t.hostSeen
+	t.lock.RUnlock()
+
+	// filter incoming ICMPs to be limited to RouterSolicits, passive taps don't need to receive anything at all
+	f := &ipv6.ICMPFilter{}
+	f.SetAll(true)
+	if respond || observe {
+		f.Accept(ipv6.ICMPTypeRouterSolicitation)
+	}
+	if respondNS && !observe {
+		f.Accept(ipv6.ICMPTypeNeighborSolicitation)
+	}
+	// any solicit tells the host is up, even the ones not answered
+	if waitForHost && !observe {
+		f.Accept(ipv6.ICMPTypeRouterSolicitation)
+		f.Accept(ipv6.ICMPTypeNeighborSolicitation)
+	}
+	for _, typ := range accept {
+		f.Accept(typ)
+	}
+	if err := c.SetICMPFilter(f); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("failed to apply ICMP type filter: %v", err)
+	}
+
+	// We are a "router", lets join the MC group. not in dry-run, we are not supposed to show up as one.
+	// observing needs the solicits, joining alone doesn't make us a router
+	if (respond && !dryRun) || observe {
+		if err := t.joinAllRouters(c); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	hw := t.refreshHardwareAddr()
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": ip.String()}).
+		Infof("handling interface: %s, mac: %s, src ip: %s", t.Ifi.Name, hw, ip)
+	// some switches filter on the RA source, so it has to be obvious which one got picked
+	if lls := linkLocalAddrs(t.Ifi); len(lls) > 1 {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": ip.String()}).
+			Infof("%s has multiple link-local addresses %s, sending from %s", t.Ifi.Name, lls, ip)
+	}
+	t.lock.Lock()
+	t.addr = ip
+	t.lock.Unlock()
+	if t.onListening != nil {
+		t.onListening()
+	}
+
+	return c, nil
+}
+
+// joinAllRouters joins the all-routers group, retrying with backoff unless the error is permanent
+func (t *Tap) joinAllRouters(c ndpConn) error {
+	if t.Ifi.Flags&net.FlagMulticast == 0 {
+		return fmt.Errorf("failed to join multicast group: %s doesn't support multicast", t.Ifi.Name)
+	}
+	backoff := joinRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := c.JoinGroup(net.IPv6linklocalallrouters)
+		if err == nil {
+			return nil
+		}
+		// nothing retrying would change
+		if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("failed to join multicast group, permanent error: %v", err)
+		}
+		if attempt >= joinRetries {
+			return fmt.Errorf("failed to join multicast group after %d attempts: %v", attempt, err)
+		}
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("failed to join multicast group: %v, retrying in %v... %d", err, backoff, attempt)
+		select {
+		case <-t.ctx.Done():
+			return context.Canceled
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// sawHost records a solicit of a host on the tap. the first one with WaitForHost set triggers an RA with the prefixes
+func (t *Tap) sawHost(from net.IP) {
+	t.lock.Lock()
+	first := t.WaitForHost && !t.hostSeen
+	t.hostSeen = true
+	t.lock.Unlock()
+	if !first {
+		return
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name, "Source": from.String()}).
+		Infof("%s got the first solicit from %s, advertising prefixes %s", t.Ifi.Name, from, t.Prefixes())
+	t.trigger(nil)
+}
+
+// refreshHardwareAddr re-reads the MAC of the interface, it may have changed while the link was down. for a VLAN
+// subinterface this is its own MAC, which hosts on the VLAN have to send to, even if inherited from the parent
+func (t *Tap) refreshHardwareAddr() net.HardwareAddr {
+	t.lock.RLock()
+	hw := t.hwAddr
+	t.lock.RUnlock()
+	current, parent, err := linkHardwareAddr(t.Ifi.Index)
+	if err != nil {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Warnf("unable to re-read mac of %s, keeping %s: %v", t.Ifi.Name, hw, err)
+		return hw
+	}
+	if parent != "" {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Debugf("%s is a vlan sharing mac %s with %s", t.Ifi.Name, current, parent)
+	}
+	if bytes.Equal(hw, current) {
+		return hw
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).Infof("%s mac changed from %s to %s", t.Ifi.Name, hw, current)
+	if !usableHardwareAddr(current) {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("%s has no usable hardware address (%q), not sending the source link-layer address option", t.Ifi.Name, current)
+	}
+	t.lock.Lock()
+	t.hwAddr = current
+	t.lock.Unlock()
+	return current
+}
+
+// HardwareAddr returns the current MAC of the interface - thread safe
+func (t *Tap) HardwareAddr() net.HardwareAddr {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.hwAddr
+}
+
+// Source returns the link-local address RAs are sent from, nil until listening
+func (t *Tap) Source() net.IP {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.addr
+}
+
+// usableHardwareAddr tells if the MAC can be advertised as our link-layer address. empty, all-zero or
+// multicast ones would make hosts send their traffic nowhere. locally administered ones are fine, most taps use those
+func usableHardwareAddr(mac net.HardwareAddr) bool {
+	if len(mac) == 0 || mac[0]&0x01 != 0 {
+		return false
+	}
+	for _, b := range mac {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// linkLocalAddrs lists the link-local addresses of the interface
+func linkLocalAddrs(ifi *net.Interface) []net.IP {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil
+	}
+	var lls []net.IP
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() == nil && n.IP.IsLinkLocalUnicast() {
+			lls = append(lls, n.IP)
+		}
+	}
+	return lls
+}
+
+// Prefixes returns the /64 prefixes currently advertised for SLAAC
+func (t *Tap) Prefixes() []net.IP {
+	prefixes, _, _ := t.routes.get()
+	return prefixes
+}
+
+// IPs returns the host routes pointing to the tap, the prefixes are derived from
+func (t *Tap) IPs() []*net.IPNet {
+	return t.routes.hostRoutes()
+}
+
+// Subnets returns the subnet routes currently pointing to the tap
+func (t *Tap) Subnets() []*net.IPNet {
+	_, _, subnets := t.routes.get()
+	return subnets
+}
+
+// RefreshRoutes re-reads the routes of the tap and triggers an RA if the advertised prefixes changed
+func (t *Tap) RefreshRoutes() error {
+	t.lock.RLock()
+	static, exclude := t.StaticPrefix, t.ExcludeSubnets
+	t.lock.RUnlock()
+	// route changes don't matter with a static prefix
+	if static != nil {
+		return nil
+	}
+	return t.updateRoutes(nil, exclude)
+}
+
+// updateRoutes sets the prefixes to the static prefix, or the ones detected from the routes (minus exclude) if nil.
+// an RA is triggered if they changed
+func (t *Tap) updateRoutes(static *net.IPNet, exclude []net.IPNet) error {
+	var changed bool
+	if static != nil {
+		changed = t.routes.update(nil, []net.IP{static.IP}, nil)
+	} else {
+		hostRoutes, subnets, err := t.routeProvider.Routes(t.Ifi.Index)
+		if err != nil {
+			return fmt.Errorf("failed getting routes for if %v: %v", t.Ifi.Name, err)
+		}
+		hostRoutes, prefixes, subnets := t.selectRoutes(hostRoutes, subnets, exclude)
+		changed = t.routes.update(hostRoutes, prefixes, subnets)
+	}
+	t.checkSubnetAnycast()
+	if !changed {
+		return nil
+	}
+
+	prefixes, deprecated, _ := t.routes.get()
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+		Infof("%s routes changed, prefixes %s, deprecated %s", t.Ifi.Name, prefixes, deprecated)
+	t.trigger(nil)
+	return nil
+}
+
+// checkSubnetAnycast records the prefixes the tap holds the subnet-router anycast address of and warns about new ones
+func (t *Tap) checkSubnetAnycast() {
+	prefixes, _, _ := t.routes.get()
+	anycast := subnetAnycasts(t.Ifi, prefixes)
+	if t.routes.setAnycast(anycast) && len(anycast) > 0 {
+		ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+			Warnf("%s holds the subnet-router anycast address of %s, this confuses neighbor discovery of some stacks", t.Ifi.Name, anycast)
+	}
+}
+
+// prefixFlags returns the flags to advertise for a /64 prefix
+func (t *Tap) prefixFlags(prefix net.IP) PrefixFlags {
+	if f, ok := t.PrefixFlags[fmt.Sprintf("%s/64", prefix)]; ok {
+		return f
+	}
+	switch t.Mode {
+	case ModeAddressingOnly:
+		return PrefixFlags{OnLink: true, Autonomous: true}
+	case ModeOnLinkOnly:
+		return PrefixFlags{OnLink: true, Autonomous: false}
+	}
+	if t.ULAOnLinkOnly && classifyPrefix(prefix) == prefixULA {
+		return PrefixFlags{OnLink: true, Autonomous: false}
+	}
+	// a host route only makes that one address reachable through us, a subnet route the whole /64 on this link
+	f := defaultPrefixFlags
+	if t.HostRouteOnLink || t.coveredBySubnet(prefix) {
+		f.OnLink = true
+	}
+	return f
+}
+
+// prefixSource tells where a currently advertised prefix came from
+func (t *Tap) prefixSource(prefix net.IP) PrefixSource {
+	if t.StaticPrefix != nil && t.StaticPrefix.IP.Equal(prefix) {
+		return PrefixSourceStatic
+	}
+	if t.coveredBySubnet(prefix) {
+		return PrefixSourceSubnet
+	}
+	return PrefixSourceHostRoute
+}
+
+// coveredBySubnet checks if the whole /64 prefix is routed to the tap by a subnet route
+func (t *Tap) coveredBySubnet(prefix net.IP) bool {
+	_, _, subnets := t.routes.get()
+	for _, s := range subnets {
+		if l, _ := s.Mask.Size(); l <= 64 && s.Contains(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// clampLifetime limits the lifetime l to the max its field can hold, instead of it being truncated on the wire
+func (t *Tap) clampLifetime(name string, l, max time.Duration) time.Duration {
+	if l <= max {
+		return l
+	}
+	ll.WithFields(ll.Fields{"Interface": t.Ifi.Name}).
+		Warnf("%s %s %v exceeds the protocol maximum, clamping to %v", t.Ifi.Name, name, l, max)
+	return max
+}
+
+// dnsServers verifies the dns servers, clamping their lifetimes to what fits the RDNSS option
+func (t *Tap) dnsServers(servers []DNSServer) ([]DNSServer, error) {
+	var checked []DNSServer
+	for _, d := range servers {
+		if err := checkDNSServer(d); err != nil {
+			return nil, err
+		}
+		d.Lifetime = t.clampLifetime("dns server "+d.Addr.String()+" lifetime", d.Lifetime, maxOptionLifetime)
+		checked = append(checked, d)
+	}
+	return checked, nil
+}
+
+// icmpTypes are the ICMPv6 types which can be accepted in addition to the solicits
+var icmpTypes = map[string]ipv6.ICMPType{
+	"router-advertisement":   ipv6.ICMPTypeRouterAdvertisement,
+	"neighbor-solicitation":  ipv6.ICMPTypeNeighborSolicitation,
+	"neighbor-advertisement": ipv6.ICMPTypeNeighborAdvertisement,
+	"redirect":               ipv6.ICMPTypeRedirect,
+}
+
+// icmpTypeNames returns the sorted names of icmpTypes
+func icmpTypeNames() []string {
+	var names []string
+	for n := range icmpTypes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// priorityPreference maps a numeric priority to the RFC 4191 router preference
+func priorityPreference(p int) ndp.Preference {
+	switch {
+	case p > 0:
+		return ndp.High
+	case p < 0:
+		return ndp.Low
+	default:
+		return ndp.Medium
+	}
+}
+
+// parsePreference maps the RFC 4191 preference names to ndp values, empty defaults to medium
+func parsePreference(p string) (ndp.Preference, error) {
+	switch strings.ToLower(p) {
+	case "", "medium":
+		return ndp.Medium, nil
+	case "high":
+		return ndp.High, nil
+	case "low":
+		return ndp.Low, nil
+	case "reserved", "10":
+		// hosts have to treat the reserved value as medium (RFC 4191 section 2.2), so refusing it right away
+		return ndp.Medium, fmt.Errorf("preference %q is reserved, must be one of high, medium or low", p)
+	}
+	return ndp.Medium, fmt.Errorf("invalid preference %q, must be one of high, medium or low", p)
+}
+
+// validDomain checks if d is a syntactically valid fully qualified domain name (without the trailing dot)
+func validDomain(d string) bool {
+	if len(d) == 0 || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,257 @@
+package radunnumbered
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/ndp"
+	"github.com/vishvananda/netlink"
+)
+
+func TestUsableHardwareAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		mac  net.HardwareAddr
+		want bool
+	}{
+		{"nil", nil, false},
+		{"empty", net.HardwareAddr{}, false},
+		{"all zero", net.HardwareAddr{0, 0, 0, 0, 0, 0}, false},
+		{"multicast", net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}, false},
+		{"broadcast", net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false},
+		{"locally administered", net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, true},
+		{"global", net.HardwareAddr{0x00, 0x16, 0x3e, 0x12, 0x34, 0x56}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := usableHardwareAddr(tt.mac); got != tt.want {
+				t.Errorf("usableHardwareAddr(%q) = %v, want %v", tt.mac, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdvertisementWithoutHardwareAddr(t *testing.T) {
+	tap := newTestTap(t, TapConfig{}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	if len(tap.Ifi.HardwareAddr) != 0 {
+		t.Skipf("%s has hardware address %s", tap.Ifi.Name, tap.Ifi.HardwareAddr)
+	}
+
+	for _, mac := range []net.HardwareAddr{tap.HardwareAddr(), {0, 0, 0, 0, 0, 0}} {
+		tap.lock.Lock()
+		tap.hwAddr = mac
+		ra := tap.advertisement()
+		tap.lock.Unlock()
+
+		if !tap.IncludeSLLA {
+			t.Fatal("source link-layer address option not enabled by default")
+		}
+		for _, o := range ra.Options {
+			if lla, ok := o.(*ndp.LinkLayerAddress); ok {
+				t.Errorf("mac %q advertised as link-layer address option %+v", mac, lla)
+			}
+		}
+	}
+}
+
+func TestListenCanceledWhileRetrying(t *testing.T) {
+	dials := make(chan struct{}, 16)
+	stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+		dials <- struct{}{}
+		return nil, nil, errors.New("link-local address not ready")
+	})
+	tap := newTestTap(t, TapConfig{}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	tap.SetLinkUp(true)
+
+	done := make(chan error, 1)
+	go func() { done <- tap.Listen() }()
+	select {
+	case <-dials:
+	case <-time.After(time.Second):
+		t.Fatal("Listen never dialed")
+	}
+
+	// the retry waits at least half of dialBackoffMin, closing has to cut it short
+	closed := time.Now()
+	tap.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Listen returned %v, want %v", err, context.Canceled)
+		}
+		if took := time.Since(closed); took >= dialBackoffMin/2 {
+			t.Errorf("Listen took %v to return after closing", took)
+		}
+	case <-time.After(dialBackoffMin):
+		t.Fatal("Listen didn't return after closing the tap")
+	}
+	if len(dials) != 0 {
+		t.Errorf("dialed %d more times after closing", len(dials))
+	}
+}
+
+func TestDialRetriesWithoutLinkLocal(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   net.IP
+	}{
+		{"unspecified", net.IPv6unspecified},
+		{"empty", nil},
+		{"global", net.ParseIP("2001:db8::1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad, good := newFakeConn(), newFakeConn()
+			var dials int
+			stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+				dials++
+				if dials == 1 {
+					return bad, tt.ip, nil
+				}
+				return good, net.ParseIP("fe80::1"), nil
+			})
+			// lo doesn't do multicast, joining the all-routers group would fail
+			respond := false
+			tap := newTestTap(t, TapConfig{RespondToRS: &respond}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+			tap.SetLinkUp(true)
+
+			c, err := tap.dial()
+			if err != nil {
+				t.Fatalf("dial failed: %v", err)
+			}
+			if c != good {
+				t.Error("dial didn't return the conn with a link-local address")
+			}
+			if dials != 2 {
+				t.Errorf("dialed %d times, want 2", dials)
+			}
+			if !bad.isClosed() {
+				t.Error("conn without link-local address not closed")
+			}
+			if good.isClosed() {
+				t.Error("conn with link-local address closed")
+			}
+			if src := tap.Source(); !src.Equal(net.ParseIP("fe80::1")) {
+				t.Errorf("source %s, want fe80::1", src)
+			}
+		})
+	}
+}
+
+// vethPair creates a veth pair removed with the test, returning the first end. needs root
+func vethPair(t *testing.T, name, peer string) netlink.Link {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("creating interfaces needs root")
+	}
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Skipf("unable to create veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(veth) })
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatalf("unable to look up %s: %v", name, err)
+	}
+	return link
+}
+
+// assertOwnHardwareAddr gives link a new mac after a tap got created on it, then checks the tap advertises that
+// one in the SLLA of its RAs and the target link-layer address of its NAs once it dialed, instead of old
+func assertOwnHardwareAddr(t *testing.T, link netlink.Link, old net.HardwareAddr) {
+	t.Helper()
+	c := newFakeConn()
+	stubListen(t, func(ifi *net.Interface, addr ndp.Addr) (ndpConn, net.IP, error) {
+		return c, net.ParseIP("fe80::1"), nil
+	})
+	respond := false
+	tap, err := NewTap(link.Attrs().Index, WithConfig(TapConfig{RespondToRS: &respond, RespondToNS: true}),
+		WithRouteProvider(&fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")}))
+	if err != nil {
+		t.Fatalf("NewTap failed: %v", err)
+	}
+	t.Cleanup(tap.Close)
+	tap.SetLinkUp(true)
+
+	own := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x01, 0x00}
+	if err := netlink.LinkSetHardwareAddr(link, own); err != nil {
+		t.Fatalf("unable to set mac of %s: %v", link.Attrs().Name, err)
+	}
+	if _, err := tap.dial(); err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	tap.lock.RLock()
+	ra := tap.advertisement()
+	tap.lock.RUnlock()
+	var slla net.HardwareAddr
+	for _, o := range ra.Options {
+		if lla, ok := o.(*ndp.LinkLayerAddress); ok && lla.Direction == ndp.Source {
+			slla = lla.Addr
+		}
+	}
+	if slla.String() != own.String() {
+		t.Errorf("source link-layer address %s, want %s of %s itself, not %s", slla, own, link.Attrs().Name, old)
+	}
+
+	tap.answerNS(c, &ndp.NeighborSolicitation{TargetAddress: net.ParseIP("fe80::1")}, net.ParseIP("fe80::2"))
+	select {
+	case msg := <-c.out:
+		na, ok := msg.m.(*ndp.NeighborAdvertisement)
+		if !ok {
+			t.Fatalf("sent %T, want a neighbor advertisement", msg.m)
+		}
+		var tlla net.HardwareAddr
+		for _, o := range na.Options {
+			if lla, ok := o.(*ndp.LinkLayerAddress); ok && lla.Direction == ndp.Target {
+				tlla = lla.Addr
+			}
+		}
+		if tlla.String() != own.String() {
+			t.Errorf("target link-layer address %s, want %s of %s itself, not %s", tlla, own, link.Attrs().Name, old)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("neighbor solicitation not answered")
+	}
+}
+
+func TestHardwareAddrRefreshedOnDial(t *testing.T) {
+	link := vethPair(t, "radmac0", "radmac1")
+	assertOwnHardwareAddr(t, link, link.Attrs().HardwareAddr)
+}
+
+func TestVLANHardwareAddr(t *testing.T) {
+	parent := vethPair(t, "radvlan0", "radvlan1")
+	vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "radvlan0.100", ParentIndex: parent.Attrs().Index}, VlanId: 100}
+	if err := netlink.LinkAdd(vlan); err != nil {
+		t.Skipf("unable to create vlan, 8021q missing?: %v", err)
+	}
+	link, err := netlink.LinkByName(vlan.Name)
+	if err != nil {
+		t.Fatalf("unable to look up %s: %v", vlan.Name, err)
+	}
+	// a vlan starts out with the mac of its parent, the tap has to follow once it got its own
+	if got, want := link.Attrs().HardwareAddr.String(), parent.Attrs().HardwareAddr.String(); got != want {
+		t.Fatalf("vlan mac %s, want inherited %s", got, want)
+	}
+	assertOwnHardwareAddr(t, link, parent.Attrs().HardwareAddr)
+}
+
+func TestSetDNSServersClampsLifetime(t *testing.T) {
+	tap := newTestTap(t, TapConfig{}, &fakeRoutes{hostRoutes: cidrs("2001:db8:1::5/128")})
+	dns := net.ParseIP("2001:db8::53")
+	// the RDNSS lifetime is a 32bit field in seconds, more would wrap around
+	if _, err := tap.SetDNSServers([]DNSServer{{Addr: dns, Lifetime: 1 << 33 * time.Second}}); err != nil {
+		t.Fatalf("SetDNSServers failed: %v", err)
+	}
+	tap.lock.RLock()
+	servers := tap.DNSServers
+	tap.lock.RUnlock()
+	if len(servers) != 1 || servers[0].Lifetime != maxOptionLifetime {
+		t.Errorf("dns servers %+v, want %s with lifetime clamped to %v", servers, dns, maxOptionLifetime)
+	}
+}
@@ -0,0 +1,38 @@
+package radunnumbered
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Version, Commit and BuildDate describe the build, injected via ldflags, i.e.
+// -ldflags "-X github.com/linode/rad-unnumbered/radunnumbered.Version=v1.2.3"
+var (
+	Version   = ""
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// VersionInfo is the json representation of the build served at /version
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+}
+
+// GetVersion returns the build info. without a version injected, the module version is used (if built via go install)
+func GetVersion() VersionInfo {
+	v := Version
+	if v == "" {
+		v = "dev"
+		if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			v = bi.Main.Version
+		}
+	}
+	return VersionInfo{Version: v, Commit: Commit, BuildDate: BuildDate}
+}
+
+// String formats the build info for humans
+func (v VersionInfo) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s)", v.Version, v.Commit, v.BuildDate)
+}